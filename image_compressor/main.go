@@ -3,16 +3,14 @@ package main
 import (
 	"errors"
 	"fmt"
-	"image"
 	"image/jpeg"
-	_ "image/png"
 	"math"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 
-	"github.com/h2non/filetype"
+	"github.com/mattanapol/image_manager/internal/image_helper"
 	"github.com/nfnt/resize"
 	"golang.org/x/exp/slices"
 )
@@ -34,6 +32,10 @@ func main() {
 	defaultScale := 0.8
 	jpegQuality := 70
 	concurrency := 5
+	// Also delete originals in other formats than JPEG (HEIC, WebP, PNG, ...) once converted to
+	// JPEG. Set to false to keep them, the conversion does not carry over their EXIF and HEIF
+	// metadata.
+	deleteConverted := true
 
 	flattenFolder(folderPath)
 	var processedFiles []string
@@ -45,7 +47,7 @@ func main() {
 	for i := 0; i < concurrency; i++ {
 		go func() {
 			for path := range fileChan {
-				err := processFile(path, thresholdSize, minResolution, outputPostfix, enableResize, defaultScale, jpegQuality, &processedFiles)
+				err := processFile(path, thresholdSize, minResolution, outputPostfix, enableResize, defaultScale, jpegQuality, deleteConverted, &processedFiles)
 				if err != nil {
 					fmt.Println("Error processing file:", path, "Error:", err)
 				}
@@ -96,75 +98,60 @@ func main() {
 	}
 }
 
-func processFile(path string, thresholdSize int64, minResolution uint, outputPostfix string, enableResize bool, defaultScale float64, jpegQuality int, processedFiles *[]string) error {
+func processFile(path string, thresholdSize int64, minResolution uint, outputPostfix string, enableResize bool, defaultScale float64, jpegQuality int, deleteConverted bool, processedFiles *[]string) error {
 	fmt.Println("Processing file:", path)
-	file, err := os.Open(path)
+	if !image_helper.IsImage(path) {
+		return nil
+	}
+
+	fileInfo, err := os.Stat(path)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	head := make([]byte, 261)
-	file.Read(head)
-	kind, _ := filetype.Match(head)
-	if kind == filetype.Unknown {
+	if fileInfo.Size() <= thresholdSize {
 		return nil
 	}
+	img, kind, err := image_helper.Decode(path)
+	if err != nil {
+		fmt.Println("Error decoding image:", err)
+		return err
+	}
 
-	if strings.Contains(kind.MIME.Type, "image") {
-		fileInfo, err := file.Stat()
-		if err != nil {
-			return err
-		}
-
-		if fileInfo.Size() <= thresholdSize {
-			return nil
-		}
-		file.Seek(0, 0)
-		img, _, err := image.Decode(file)
-		if err != nil {
-			fmt.Println("Error decoding image config:", err)
-			return err
-		}
-
-		width, height := img.Bounds().Dx(), img.Bounds().Dy()
-		newHeight := uint(math.Max(float64(height)*defaultScale, float64(minResolution)))
-		if enableResize && newHeight < uint(height) {
-			originalRatio := float64(width) / float64(height)
-			newWidth := uint(originalRatio * float64(newHeight))
-
-			file.Seek(0, 0)
-			if err != nil {
-				fmt.Println("Error image decode:", err)
-				return err
-			}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	newHeight := uint(math.Max(float64(height)*defaultScale, float64(minResolution)))
+	if enableResize && newHeight < uint(height) {
+		originalRatio := float64(width) / float64(height)
+		newWidth := uint(originalRatio * float64(newHeight))
 
-			img = resize.Resize(newWidth, newHeight, img, resize.Lanczos3)
-		}
+		img = resize.Resize(newWidth, newHeight, img, resize.Lanczos3)
+	}
 
-		fileName := strings.TrimSuffix(filepath.Base(path), filepath.Ext(filepath.Base(path)))
-		outputPath := filepath.Join(filepath.Dir(path), fileName+outputPostfix+".jpg")
-		out, err := os.Create(outputPath)
-		if err != nil {
-			return err
-		}
-		defer out.Close()
+	fileName := strings.TrimSuffix(filepath.Base(path), filepath.Ext(filepath.Base(path)))
+	outputPath := filepath.Join(filepath.Dir(path), fileName+outputPostfix+".jpg")
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
 
-		err = jpeg.Encode(out, img, &jpeg.Options{Quality: jpegQuality})
-		if err != nil {
-			return err
-		}
+	err = jpeg.Encode(out, img, &jpeg.Options{Quality: jpegQuality})
+	if err != nil {
+		return err
+	}
 
+	if kind.MIME.Value == "image/jpeg" || deleteConverted {
 		err = os.Remove(path)
 		if err != nil {
 			fmt.Println("Error deleting file:", path, "Error:", err)
 		}
-		countMutex.Lock()
-		processFileCount++
-		countMutex.Unlock()
-
-		// *processedFiles = append(*processedFiles, path)
+	} else {
+		fmt.Println("Keeping the original of converted file:", path)
 	}
+	countMutex.Lock()
+	processFileCount++
+	countMutex.Unlock()
+
+	// *processedFiles = append(*processedFiles, path)
 	return nil
 }
 
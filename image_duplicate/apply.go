@@ -0,0 +1,211 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/mattanapol/image_manager/internal/csv_helper"
+	"github.com/mattanapol/image_manager/internal/file_helper"
+)
+
+// Actions of the action column of results.csv, see runApplyCommand
+const (
+	actionKeep     = "keep"
+	actionDelete   = "delete"
+	actionMove     = "move"
+	actionHardlink = "hardlink"
+)
+
+// journalFile is the file the executed actions are appended to, next to the results by default.
+const journalFile = "journal.csv"
+
+var journalHeaders = []string{"time", "groupId", "action", "filePath", "target", "result"}
+
+// plannedAction is an action on a file with the keeper of its group.
+type plannedAction struct {
+	GroupID int
+	Action  string
+	Path    string
+	Keeper  string
+	Kind    string // Kind of the duplicate, recorded in the manifest of moved files
+}
+
+// runApplyCommand executes the actions marked in the action column of a results file. Every row
+// is validated first, nothing is changed unless each group with an action keeps a file and every
+// file listed still has the size and modification time of the scan. Every executed action is
+// appended to a journal.
+func runApplyCommand(args []string) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	results := fs.String("results", outputFile, fmt.Sprintf("Results file (%s or %s) with the action column filled in: keep, delete, move or hardlink. Rows without an action are left alone.", outputFile, exactFile))
+	moveTo := fs.String("move-to", "", "Folder the move action moves files to, mirroring their paths, with a manifest to restore them.")
+	journal := fs.String("journal", "", fmt.Sprintf("File every executed action is appended to (default %s next to the results).", journalFile))
+	dryRun := fs.Bool("dry-run", false, "Only validate the results file and print the actions.")
+	fs.Parse(args)
+	if *journal == "" {
+		*journal = filepath.Join(filepath.Dir(*results), journalFile)
+	}
+
+	groups, err := readResults(*results)
+	if err != nil {
+		fmt.Printf("Error reading results: %v\n", err)
+		os.Exit(1)
+	}
+	actions, problems := planActions(groups, *moveTo)
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		fmt.Printf("Found %d problems, nothing was changed. Fix %s and run apply again.\n", len(problems), *results)
+		os.Exit(1)
+	}
+	if len(actions) == 0 {
+		fmt.Println("No actions to apply.")
+		return
+	}
+	if *dryRun {
+		for _, action := range actions {
+			fmt.Printf("%s %s (group %d, keeper %s)\n", action.Action, action.Path, action.GroupID, action.Keeper)
+		}
+		fmt.Printf("%d actions to apply.\n", len(actions))
+		return
+	}
+	applyActions(actions, *moveTo, *journal)
+}
+
+// planActions validates the rows of the groups and returns the actions to execute, or the
+// problems found. The first kept file of a group is its keeper.
+func planActions(groups []resultGroup, moveTo string) ([]plannedAction, []string) {
+	var actions []plannedAction
+	var problems []string
+	for _, group := range groups {
+		keeper := ""
+		var groupActions []plannedAction
+		for _, member := range group.Members {
+			switch member.Action {
+			case "":
+				continue
+			case actionKeep:
+				if keeper == "" {
+					keeper = member.Path
+				}
+			case actionMove:
+				if moveTo == "" {
+					problems = append(problems, fmt.Sprintf("Group %d: %s is to be moved, but -move-to is not set", group.ID, member.Path))
+				}
+			case actionDelete, actionHardlink:
+			default:
+				problems = append(problems, fmt.Sprintf("Group %d: unknown action %q for %s", group.ID, member.Action, member.Path))
+				continue
+			}
+			if err := checkUnchanged(member.Path, member.Size, member.ModTime); err != nil {
+				problems = append(problems, fmt.Sprintf("Group %d: %v", group.ID, err))
+			}
+			if member.Action != actionKeep {
+				groupActions = append(groupActions, plannedAction{GroupID: group.ID, Action: member.Action, Path: member.Path, Kind: member.Kind})
+			}
+		}
+		if len(groupActions) == 0 {
+			continue
+		}
+		if keeper == "" {
+			problems = append(problems, fmt.Sprintf("Group %d: no file is kept", group.ID))
+			continue
+		}
+		for _, action := range groupActions {
+			action.Keeper = keeper
+			if action.Action == actionHardlink {
+				if err := checkSameContent(action.Path, keeper); err != nil {
+					problems = append(problems, fmt.Sprintf("Group %d: cannot hardlink %s: %v", group.ID, action.Path, err))
+				}
+			}
+			actions = append(actions, action)
+		}
+	}
+	return actions, problems
+}
+
+// checkUnchanged returns an error when the file is gone or its size or modification time differ
+// from those of the scan, as recorded in the results.
+func checkUnchanged(path string, size int64, modTime time.Time) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	if info.Size() != size {
+		return fmt.Errorf("%s changed since the scan, its size is %d bytes instead of %d", path, info.Size(), size)
+	}
+	// results.csv stores the time to the second
+	if !info.ModTime().Truncate(time.Second).Equal(modTime) {
+		return fmt.Errorf("%s changed since the scan, it was modified at %s", path, info.ModTime().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// checkSameContent returns an error unless both files have the same content, a hardlink would
+// lose the other.
+func checkSameContent(path, keeper string) error {
+	same, err := file_helper.SameContent(path, keeper)
+	if err != nil {
+		return err
+	}
+	if !same {
+		return fmt.Errorf("its content differs from %s", keeper)
+	}
+	return nil
+}
+
+// applyActions executes the actions and appends each of them, with its result, to the journal.
+// An action is only executed while the keeper of its group still exists.
+func applyActions(actions []plannedAction, moveTo, journal string) {
+	if _, err := os.Stat(journal); os.IsNotExist(err) {
+		csv_helper.CreateCSVFileWithHeaders(journal, journalHeaders)
+	}
+	manifest := ""
+	if moveTo != "" {
+		var err error
+		if manifest, err = openManifest(moveTo); err != nil {
+			fmt.Printf("Error creating move folder: %v\n", err)
+			return
+		}
+	}
+
+	applied := 0
+	for _, action := range actions {
+		target, err := applyAction(action, moveTo, manifest)
+		result := "done"
+		if err != nil {
+			fmt.Printf("Error applying %s to %s: %v\n", action.Action, action.Path, err)
+			result = "error: " + err.Error()
+		} else {
+			applied++
+		}
+		csv_helper.AppendResultToCSV(journal, []string{time.Now().Format(time.RFC3339), strconv.Itoa(action.GroupID), action.Action, action.Path, target, result})
+	}
+	fmt.Printf("Applied %d of %d actions, see %s.\n", applied, len(actions), journal)
+	if moveTo != "" {
+		fmt.Printf("Moved files can be restored with: image_duplicate restore %s\n", moveTo)
+	}
+}
+
+// applyAction executes one action and returns the path the file was moved or linked to, if any.
+func applyAction(action plannedAction, moveTo, manifest string) (string, error) {
+	if _, err := os.Stat(action.Keeper); err != nil {
+		return "", fmt.Errorf("the keeper is missing: %w", err)
+	}
+	switch action.Action {
+	case actionDelete:
+		return "", os.Remove(action.Path)
+	case actionMove:
+		return quarantineFile(moveTo, manifest, quarantineMove{Path: action.Path, Keeper: action.Keeper, Kind: action.Kind, GroupID: action.GroupID})
+	case actionHardlink:
+		return action.Keeper, file_helper.ReplaceWithHardlink(action.Path, action.Keeper)
+	}
+	return "", fmt.Errorf("unknown action %q", action.Action)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlanActions(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	root := t.TempDir()
+	entries := writeFiles(t, root, []testFile{
+		{name: "keeper.jpg", content: "same", modTime: modTime},
+		{name: "dup.jpg", content: "same", modTime: modTime},
+		{name: "other.jpg", content: "other", modTime: modTime},
+	})
+	keeper, dup, other := entries[0].Path, entries[1].Path, entries[2].Path
+	row := func(path, action, kind string) resultRow {
+		return resultRow{GroupID: 1, Path: path, Kind: kind, Size: int64(len("same")), ModTime: modTime, Action: action}
+	}
+
+	tests := []struct {
+		name     string
+		rows     []resultRow
+		moveTo   string
+		setup    func(t *testing.T)
+		want     []plannedAction
+		problems []string // Texts every problem must contain, one per problem
+	}{
+		{
+			name: "hardlink an exact copy",
+			rows: []resultRow{row(keeper, actionKeep, kindExact), row(dup, actionHardlink, kindExact)},
+			want: []plannedAction{{GroupID: 1, Action: actionHardlink, Path: dup, Keeper: keeper, Kind: kindExact}},
+		},
+		{
+			name:   "move keeps the kind of the row",
+			rows:   []resultRow{row(dup, actionMove, kindSimilar), row(keeper, actionKeep, kindSimilar)},
+			moveTo: "quarantine",
+			want:   []plannedAction{{GroupID: 1, Action: actionMove, Path: dup, Keeper: keeper, Kind: kindSimilar}},
+		},
+		{
+			name: "rows without an action",
+			rows: []resultRow{row(keeper, "", kindSimilar), row(dup, "", kindSimilar)},
+		},
+		{
+			name:     "group with no keeper",
+			rows:     []resultRow{row(keeper, actionDelete, kindSimilar), row(dup, actionDelete, kindSimilar)},
+			problems: []string{"no file is kept"},
+		},
+		{
+			name:     "missing keeper",
+			rows:     []resultRow{row(filepath.Join(root, "gone.jpg"), actionKeep, kindSimilar), row(dup, actionDelete, kindSimilar)},
+			problems: []string{"gone.jpg"},
+		},
+		{
+			name: "file changed since the scan",
+			rows: []resultRow{row(keeper, actionKeep, kindSimilar), row(dup, actionDelete, kindSimilar)},
+			setup: func(t *testing.T) {
+				if err := os.Chtimes(dup, modTime, modTime.Add(time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			problems: []string{"changed since the scan, it was modified"},
+		},
+		{
+			name:     "size changed since the scan",
+			rows:     []resultRow{row(keeper, actionKeep, kindSimilar), {GroupID: 1, Path: other, Size: 4, ModTime: modTime, Action: actionDelete}},
+			problems: []string{"its size is 5 bytes instead of 4"},
+		},
+		{
+			name:     "hardlink to different content",
+			rows:     []resultRow{row(keeper, actionKeep, kindSimilar), {GroupID: 1, Path: other, Size: 5, ModTime: modTime, Action: actionHardlink}},
+			problems: []string{"content differs"},
+		},
+		{
+			name:     "move without a folder",
+			rows:     []resultRow{row(keeper, actionKeep, kindSimilar), row(dup, actionMove, kindSimilar)},
+			problems: []string{"-move-to is not set"},
+		},
+		{
+			name:     "unknown action",
+			rows:     []resultRow{row(keeper, actionKeep, kindSimilar), row(dup, "remove", kindSimilar)},
+			problems: []string{`unknown action "remove"`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := os.Chtimes(dup, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+			if test.setup != nil {
+				test.setup(t)
+			}
+			actions, problems := planActions([]resultGroup{{ID: 1, Members: test.rows}}, test.moveTo)
+			if len(problems) != len(test.problems) {
+				t.Fatalf("problems = %q, want %d", problems, len(test.problems))
+			}
+			for i, problem := range problems {
+				if !strings.Contains(problem, test.problems[i]) {
+					t.Errorf("problem %q does not contain %q", problem, test.problems[i])
+				}
+			}
+			if len(test.problems) == 0 && !slices.Equal(actions, test.want) {
+				t.Errorf("actions = %+v, want %+v", actions, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyActions(t *testing.T) {
+	root := t.TempDir()
+	entries := writeFiles(t, root, []testFile{
+		{name: "keeper.jpg", content: "same"},
+		{name: "delete.jpg", content: "same"},
+		{name: "move.jpg", content: "similar"},
+		{name: "link.jpg", content: "same"},
+	})
+	keeper := entries[0].Path
+	moveTo, journal := filepath.Join(root, "moved"), filepath.Join(root, journalFile)
+	applyActions([]plannedAction{
+		{GroupID: 1, Action: actionDelete, Path: entries[1].Path, Keeper: keeper, Kind: kindExact},
+		{GroupID: 2, Action: actionMove, Path: entries[2].Path, Keeper: keeper, Kind: kindSimilar},
+		{GroupID: 1, Action: actionHardlink, Path: entries[3].Path, Keeper: keeper, Kind: kindExact},
+	}, moveTo, journal)
+
+	if _, err := os.Lstat(entries[1].Path); !os.IsNotExist(err) {
+		t.Errorf("%s was not deleted", entries[1].Path)
+	}
+	if !isSameFile(entries[3].Path, keeper) {
+		t.Errorf("%s is not a hardlink of the keeper", entries[3].Path)
+	}
+	records, err := readManifest(filepath.Join(moveTo, manifestFileName))
+	if err != nil {
+		t.Fatalf("readManifest: %v", err)
+	}
+	if len(records) != 1 || records[0][0] != entries[2].Path || records[0][2] != kindSimilar {
+		t.Errorf("manifest = %q, want the move of %s", records, entries[2].Path)
+	}
+	content, err := os.ReadFile(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(content), "\n"); lines != 4 || strings.Count(string(content), ",done") != 3 {
+		t.Errorf("journal =\n%s\nwant a header and 3 done actions", content)
+	}
+}
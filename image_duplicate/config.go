@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+)
+
+// config holds every setting of a run. It is read from the optional JSON file given with -config,
+// flags set on the command line override its values.
+type config struct {
+	Roots      []string `json:"roots"`
+	Threads    int      `json:"threads"`
+	Threshold  int      `json:"threshold"` // Minimum similarity percentage of a pair
+	Output     string   `json:"output"`    // Folder the CSV reports are written to
+	Algorithm  string   `json:"algorithm"`
+	Skip       []string `json:"skip"` // Paths containing one of these are ignored
+	Sample     bool     `json:"sample"`
+	Keep       string   `json:"keep"`
+	PreferRoot string   `json:"preferRoot"`
+	Quarantine string   `json:"quarantine"`
+	HTML       bool     `json:"html"`
+	Link       string   `json:"link"` // Replace exact copies with hardlinks or reflinks, see linkExactCopies
+}
+
+// defaultConfig returns the settings used when neither a flag nor the config file sets them.
+func defaultConfig() config {
+	return config{
+		Threads:   2,
+		Threshold: 99,
+		Output:    ".",
+		Algorithm: "perception", // Shares the cached hashes with image_similar_finder
+		Skip:      []string{"$RECYCLE.BIN"},
+		Keep:      defaultKeeperPolicy,
+	}
+}
+
+// listFlag is a flag that can be repeated or given a comma separated list.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// parseConfig parses the command line, merges it with the config file and validates the result.
+func parseConfig(args []string) (config, error) {
+	defaults := defaultConfig()
+	var flags config
+	var roots, skip listFlag
+	fs := flag.NewFlagSet("image_duplicate", flag.ExitOnError)
+	configFile := fs.String("config", "", "JSON file with the settings, flags given on the command line override it. "+
+		`Keys: roots, threads, threshold, output, algorithm, skip, sample, keep, preferRoot, quarantine, html, link.`)
+	fs.Var(&roots, "root", "Folder to search, repeat the flag or separate folders with commas to search several (required).")
+	fs.IntVar(&flags.Threads, "threads", defaults.Threads, "Number of files read and hashed at the same time.")
+	fs.IntVar(&flags.Threshold, "threshold", defaults.Threshold, "Similarity percentage (0-100) two images need to be reported as duplicates.")
+	fs.StringVar(&flags.Output, "output", defaults.Output, "Folder to write the results.csv, exact.csv and folders.csv reports to.")
+	fs.StringVar(&flags.Algorithm, "algo", defaults.Algorithm, "Hash algorithm: average, difference or perception.")
+	fs.Var(&skip, "skip", fmt.Sprintf("Ignore the paths containing this text, repeat the flag or separate entries with commas (default %q).", strings.Join(defaults.Skip, ",")))
+	fs.BoolVar(&flags.Sample, "sample", defaults.Sample, fmt.Sprintf("Only compare against a random sample of the images (%d%%, at most %d per folder) and skip pairs in the same folder. "+
+		"Faster, but misses most duplicates and two runs give different results.", percentSave, hashSavePerFolder))
+	fs.StringVar(&flags.Keep, "keep", defaults.Keep, "Comma separated rules to pick the keeper of a group, applied in order: "+
+		"resolution, size, oldest (EXIF date, else modification time), path (shortest) or root (under -prefer-root).")
+	fs.StringVar(&flags.PreferRoot, "prefer-root", defaults.PreferRoot, "Folder whose files are kept first, used by the root rule of -keep.")
+	fs.StringVar(&flags.Quarantine, "quarantine", defaults.Quarantine, "Move every duplicate image and video but the keepers into this folder, mirroring their paths, with a manifest to restore them.")
+	fs.BoolVar(&flags.HTML, "html", defaults.HTML, "Also write report.html, a self-contained page with thumbnails of every group, to the output folder.")
+	fs.StringVar(&flags.Link, "link", defaults.Link, "Replace exact copies of images and videos with links to their keeper to reclaim their space: hardlink, or reflink on file systems that can clone files (Btrfs, XFS, APFS).")
+	fs.Parse(args)
+	flags.Roots, flags.Skip = roots, skip
+	// Folders can also be given as arguments
+	flags.Roots = append(flags.Roots, fs.Args()...)
+
+	cfg := defaults
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return config{}, fmt.Errorf("error reading config: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return config{}, fmt.Errorf("error parsing config %s: %w", *configFile, err)
+		}
+	}
+	fs.Visit(func(f *flag.Flag) { cfg.override(f.Name, flags) })
+	if len(fs.Args()) > 0 {
+		cfg.override("root", flags)
+	}
+	return cfg, cfg.validate()
+}
+
+// override replaces the setting of a flag with its value in flags.
+func (c *config) override(name string, flags config) {
+	switch name {
+	case "root":
+		c.Roots = flags.Roots
+	case "threads":
+		c.Threads = flags.Threads
+	case "threshold":
+		c.Threshold = flags.Threshold
+	case "output":
+		c.Output = flags.Output
+	case "algo":
+		c.Algorithm = flags.Algorithm
+	case "skip":
+		c.Skip = flags.Skip
+	case "sample":
+		c.Sample = flags.Sample
+	case "keep":
+		c.Keep = flags.Keep
+	case "prefer-root":
+		c.PreferRoot = flags.PreferRoot
+	case "quarantine":
+		c.Quarantine = flags.Quarantine
+	case "html":
+		c.HTML = flags.HTML
+	case "link":
+		c.Link = flags.Link
+	}
+}
+
+func (c *config) validate() error {
+	if len(c.Roots) == 0 {
+		return fmt.Errorf("at least one root folder (-root) is required")
+	}
+	for _, root := range c.Roots {
+		if info, err := os.Stat(root); err != nil || !info.IsDir() {
+			return fmt.Errorf("root folder %s is not a folder", root)
+		}
+	}
+	c.Roots = outermostFolders(c.Roots)
+	if c.Threads < 1 {
+		return fmt.Errorf("threads must be at least 1")
+	}
+	if c.Threshold < 0 || c.Threshold > 100 {
+		return fmt.Errorf("threshold must be between 0 and 100")
+	}
+	if _, exists := hash_helper.Algorithms[c.Algorithm]; !exists {
+		return fmt.Errorf("unknown hash algorithm %q (expected average, difference or perception)", c.Algorithm)
+	}
+	if c.Link != "" && c.Link != linkHardlink && c.Link != linkReflink {
+		return fmt.Errorf("unknown link mode %q (expected hardlink or reflink)", c.Link)
+	}
+	if c.Link != "" && c.Quarantine != "" {
+		return fmt.Errorf("-link and -quarantine cannot be used together, quarantine would move the linked copies")
+	}
+	if c.Quarantine != "" {
+		for _, root := range c.Roots {
+			if isInside(c.Quarantine, root) {
+				return fmt.Errorf("the quarantine folder %s must be outside %s", c.Quarantine, root)
+			}
+		}
+	}
+	return nil
+}
+
+// outermostFolders drops the folders inside another folder of the list, they would be searched
+// twice.
+func outermostFolders(folders []string) []string {
+	var outermost []string
+	for i, folder := range folders {
+		nested := false
+		for j, other := range folders {
+			if i != j && isInside(folder, other) && (!isInside(other, folder) || j < i) {
+				nested = true
+				break
+			}
+		}
+		if nested {
+			fmt.Printf("Skipping root folder %s, it is inside another root folder\n", folder)
+			continue
+		}
+		outermost = append(outermost, filepath.Clean(folder))
+	}
+	return outermost
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattanapol/image_manager/internal/common"
+	"github.com/mattanapol/image_manager/internal/csv_helper"
+	"github.com/mattanapol/image_manager/internal/file_helper"
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+)
+
+// FileEntry is a file found by listFiles, of any type.
+type FileEntry struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// ExactGroup is a set of files with identical content. Members are sorted by path.
+type ExactGroup struct {
+	ID      int
+	Hash    string // SHA-256 of the content
+	Size    int64
+	Members []FileEntry
+	Keeper  int // Index of the suggested keeper in Members, see keeperPolicy
+}
+
+// metadataFiles are the files operating systems keep in folders for themselves. Their copies
+// are not duplicates of the user's files.
+var metadataFiles = []string{".DS_Store", ".localized", ".directory", "Thumbs.db", "ehthumbs.db", "desktop.ini", "Icon\r"}
+
+// isIgnoredFile reports whether a file is the hash cache, operating system metadata (including
+// the ._ AppleDouble files macOS writes on other file systems) or a file written by this tool in
+// one of the output folders. They are left out of the exact duplicates. Files named like the
+// outputs elsewhere belong to the user.
+func isIgnoredFile(path string, outputFolders []string) bool {
+	name := filepath.Base(path)
+	// The prefix also matches the temporary file the cache is saved to
+	if strings.HasPrefix(name, hash_helper.DefaultCacheFileName) || strings.HasPrefix(name, "._") {
+		return true
+	}
+	if slices.ContainsFunc(metadataFiles, func(metadata string) bool { return strings.EqualFold(name, metadata) }) {
+		return true
+	}
+	outputs := []string{outputFile, exactFile, folderFile, reportFile, journalFile, manifestFileName}
+	if !slices.Contains(outputs, name) && !strings.HasSuffix(name, decisionsSuffix) {
+		return false
+	}
+	return slices.ContainsFunc(outputFolders, func(folder string) bool { return isInside(path, folder) })
+}
+
+// listFiles returns every regular file under root that is neither blacklisted nor ignored, see
+// isIgnoredFile.
+func listFiles(root string, outputFolders []string) []FileEntry {
+	var entries []FileEntry
+	err := hash_helper.Walk(root, isBlacklisted, func(path string, d fs.DirEntry) {
+		if isIgnoredFile(path, outputFolders) {
+			return
+		}
+		info, err := d.Info()
+		if err != nil {
+			fmt.Printf("Error reading file info %q: %v\n", path, err)
+			return
+		}
+		entries = append(entries, FileEntry{Path: path, Size: info.Size(), ModTime: info.ModTime()})
+	})
+	if err != nil {
+		fmt.Printf("Error walking directory: %v\n", err)
+	}
+	return entries
+}
+
+// findExactDuplicates groups the files by size and then by the SHA-256 of their content, so only
+// files that share their size with another file are read. Empty files are ignored. The keeper of
+// each group is picked with the policy, its members only differ in path and dates.
+func findExactDuplicates(entries []FileEntry, policy keeperPolicy) []ExactGroup {
+	bySize := make(map[int64][]FileEntry)
+	for _, entry := range entries {
+		if entry.Size > 0 {
+			bySize[entry.Size] = append(bySize[entry.Size], entry)
+		}
+	}
+	var candidates []FileEntry
+	for _, sameSize := range bySize {
+		if len(sameSize) > 1 {
+			candidates = append(candidates, sameSize...)
+		}
+	}
+	fmt.Printf("Hashing the content of %d of %d files that share their size with another file...\n", len(candidates), len(entries))
+
+	// The size is part of the key so a hash collision across sizes cannot merge groups
+	type contentKey struct {
+		size int64
+		hash string
+	}
+	byContent := make(map[contentKey][]FileEntry)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, numberOfThreads)
+	for _, entry := range candidates {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(entry FileEntry) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			hash, err := file_helper.HashFile(entry.Path)
+			if err != nil {
+				fmt.Printf("Error hashing %s: %v\n", entry.Path, err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			key := contentKey{size: entry.Size, hash: hash}
+			byContent[key] = append(byContent[key], entry)
+		}(entry)
+	}
+	wg.Wait()
+
+	var groups []ExactGroup
+	for key, members := range byContent {
+		if len(members) < 2 {
+			continue
+		}
+		sort.Slice(members, func(i, j int) bool { return members[i].Path < members[j].Path })
+		candidates := make([]FileInfo, len(members))
+		for i, member := range members {
+			candidates[i] = FileInfo{Path: member.Path, Size: member.Size, ModTime: member.ModTime}
+		}
+		groups = append(groups, ExactGroup{Hash: key.hash, Size: key.size, Members: members, Keeper: policy.choose(candidates)})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Members[0].Path < groups[j].Members[0].Path })
+	for i := range groups {
+		groups[i].ID = i + 1
+	}
+	return groups
+}
+
+// keeperFirst returns the indexes of the members, the keeper first and the others in path order.
+func (g ExactGroup) keeperFirst() []int {
+	order := []int{g.Keeper}
+	for i := range g.Members {
+		if i != g.Keeper {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// mediaGroups returns the exact groups of images and videos, the files the tool moves or links.
+// Exact duplicates of other files are only reported. Members share their content, so a group is
+// media when any member is.
+func mediaGroups(groups []ExactGroup) []ExactGroup {
+	var media []ExactGroup
+	for _, group := range groups {
+		if slices.ContainsFunc(group.Members, func(member FileEntry) bool {
+			return common.IsVideo(member.Path) || hash_helper.IsImageFile(member.Path)
+		}) {
+			media = append(media, group)
+		}
+	}
+	return media
+}
+
+// exactCopies returns the paths of the exact duplicates other than the keeper of their group.
+// They are left out of the perceptual comparison, their keeper stands for them.
+func exactCopies(groups []ExactGroup) map[string]bool {
+	copies := make(map[string]bool)
+	for _, group := range groups {
+		for i, member := range group.Members {
+			if i != group.Keeper {
+				copies[member.Path] = true
+			}
+		}
+	}
+	return copies
+}
+
+// withExactCopies adds the exact copies of the perceptually compared images back, with the hash
+// of their keeper. Every copy is paired with the other members of its group and with every image
+// its keeper is similar to. The folder report needs them to know which images of a folder have a
+// match elsewhere.
+func withExactCopies(files []FileInfo, pairs []SimilarPair, groups []ExactGroup) ([]FileInfo, []SimilarPair) {
+	hashed := make(map[string]FileInfo, len(files))
+	for _, file := range files {
+		hashed[file.Path] = file
+	}
+
+	allFiles := append([]FileInfo(nil), files...)
+	allPairs := append([]SimilarPair(nil), pairs...)
+	// Every member of a group, the keeper included, by the path of the keeper
+	members := make(map[string][]string)
+	for _, group := range groups {
+		keeperPath := group.Members[group.Keeper].Path
+		keeper, isImage := hashed[keeperPath]
+		if !isImage {
+			continue
+		}
+		for i, member := range group.Members {
+			members[keeperPath] = append(members[keeperPath], member.Path)
+			if i != group.Keeper {
+				allFiles = append(allFiles, FileInfo{Path: member.Path, Hash: keeper.Hash, Width: keeper.Width, Height: keeper.Height, Size: member.Size, ModTime: member.ModTime})
+			}
+			for _, other := range group.Members[i+1:] {
+				allPairs = append(allPairs, SimilarPair{Path1: member.Path, Path2: other.Path, Similarity: 100})
+			}
+		}
+	}
+
+	// Members of a path's exact group, or only the path itself
+	expand := func(path string) []string {
+		if paths, exists := members[path]; exists {
+			return paths
+		}
+		return []string{path}
+	}
+	for _, pair := range pairs {
+		for _, path1 := range expand(pair.Path1) {
+			for _, path2 := range expand(pair.Path2) {
+				if path1 == pair.Path1 && path2 == pair.Path2 {
+					continue // Already in pairs
+				}
+				allPairs = append(allPairs, SimilarPair{Path1: min(path1, path2), Path2: max(path1, path2), Similarity: pair.Similarity})
+			}
+		}
+	}
+	return allFiles, allPairs
+}
+
+// printExactGroups prints a summary of the exact duplicates.
+func printExactGroups(groups []ExactGroup) {
+	copies := 0
+	var redundantBytes int64
+	for _, group := range groups {
+		copies += len(group.Members) - 1
+		redundantBytes += int64(len(group.Members)-1) * group.Size
+	}
+	fmt.Printf("Found %d exact duplicate groups: %d redundant copies, %.2f MB.\n", len(groups), copies, float64(redundantBytes)/(1024*1024))
+}
+
+// writeExactGroupsCSV writes one row per exact duplicate, the keeper first. Like results.csv it
+// can be reviewed and applied, the action column is set to keep for the keepers.
+func writeExactGroupsCSV(filename string, groups []ExactGroup) {
+	headers := []string{"groupId", "filePath", "keeper", "kind", "size", "modTime", "sha256", "action"}
+	csv_helper.CreateCSVFileWithHeaders(filename, headers)
+	for _, group := range groups {
+		order := []int{group.Keeper}
+		for i := range group.Members {
+			if i != group.Keeper {
+				order = append(order, i)
+			}
+		}
+		for _, i := range order {
+			member := group.Members[i]
+			action := "" // Filled in by the user for the apply command
+			if i == group.Keeper {
+				action = actionKeep
+			}
+			csv_helper.AppendResultToCSV(filename, []string{
+				strconv.Itoa(group.ID),
+				member.Path,
+				strconv.FormatBool(i == group.Keeper),
+				kindExact,
+				strconv.FormatInt(member.Size, 10),
+				member.ModTime.Format(time.RFC3339),
+				group.Hash,
+				action,
+			})
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+)
+
+// testFile is a file to create with its content and modification time.
+type testFile struct {
+	name    string
+	content string
+	modTime time.Time
+}
+
+// writeFiles creates the files under root and returns their entries.
+func writeFiles(t *testing.T, root string, files []testFile) []FileEntry {
+	t.Helper()
+	var entries []FileEntry
+	for _, file := range files {
+		path := filepath.Join(root, file.name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(file.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if !file.modTime.IsZero() {
+			if err := os.Chtimes(path, file.modTime, file.modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, FileEntry{Path: path, Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return entries
+}
+
+func TestListFilesSkipsIgnoredFiles(t *testing.T) {
+	blacklist = nil
+	root := t.TempDir()
+	writeFiles(t, root, []testFile{
+		{name: "photo.jpg", content: "photo"},
+		{name: "notes.txt", content: "notes"},
+		{name: hash_helper.DefaultCacheFileName, content: "cache"},
+		{name: hash_helper.DefaultCacheFileName + ".123.tmp", content: "cache"},
+		{name: "out/" + outputFile, content: "results"},
+		{name: "out/" + exactFile, content: "exact"},
+		{name: "out/" + reportFile, content: "report"},
+		{name: "out/" + journalFile, content: "journal"},
+		{name: "out/results.decisions.csv", content: "decisions"},
+		{name: "quarantine/" + manifestFileName, content: "manifest"},
+		{name: "quarantine/2024/" + manifestFileName, content: "manifest"},
+		{name: "documents/" + reportFile, content: "the user's report"},
+		{name: "documents/" + outputFile, content: "the user's results"},
+		{name: ".DS_Store", content: "metadata"},
+		{name: "sub/.DS_Store", content: "metadata"},
+		{name: "sub/thumbs.db", content: "metadata"},
+		{name: "sub/desktop.ini", content: "metadata"},
+		{name: "sub/._photo.jpg", content: "metadata"},
+		{name: "sub/photo.jpg", content: "photo"},
+	})
+
+	var got []string
+	for _, entry := range listFiles(root, []string{filepath.Join(root, "out"), filepath.Join(root, "quarantine")}) {
+		got = append(got, rel(t, root, entry.Path))
+	}
+	slices.Sort(got)
+	want := []string{"documents/" + reportFile, "documents/" + outputFile, "notes.txt", "photo.jpg", "sub/photo.jpg"}
+	if !slices.Equal(got, want) {
+		t.Errorf("listFiles = %v, want %v", got, want)
+	}
+}
+
+func TestFindExactDuplicates(t *testing.T) {
+	numberOfThreads = 4
+	old := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		policy string
+		files  []testFile
+		want   [][]string // Members of each group, the keeper first
+	}{
+		{
+			name:   "same size, different content",
+			policy: defaultKeeperPolicy,
+			files:  []testFile{{name: "a.jpg", content: "aaaa"}, {name: "b.jpg", content: "bbbb"}},
+		},
+		{
+			name:   "empty files",
+			policy: defaultKeeperPolicy,
+			files:  []testFile{{name: "a.jpg"}, {name: "b.jpg"}, {name: "c/d.jpg"}},
+		},
+		{
+			name:   "copies and a size collision",
+			policy: defaultKeeperPolicy,
+			files: []testFile{
+				{name: "a.jpg", content: "same"},
+				{name: "b.jpg", content: "diff"},
+				{name: "c/a copy.jpg", content: "same"},
+				{name: "unique.jpg", content: "unique"},
+			},
+			want: [][]string{{"a.jpg", "c/a copy.jpg"}},
+		},
+		{
+			name:   "oldest keeper",
+			policy: "oldest,path",
+			files: []testFile{
+				{name: "a.jpg", content: "same"},
+				{name: "b/longer path.jpg", content: "same", modTime: old},
+				{name: "c.jpg", content: "same"},
+			},
+			want: [][]string{{"b/longer path.jpg", "a.jpg", "c.jpg"}},
+		},
+		{
+			name:   "shortest path keeper",
+			policy: "path",
+			files: []testFile{
+				{name: "a/long name.jpg", content: "one"},
+				{name: "b.jpg", content: "one"},
+				{name: "c.txt", content: "two"},
+				{name: "d/c.txt", content: "two"},
+			},
+			want: [][]string{{"b.jpg", "a/long name.jpg"}, {"c.txt", "d/c.txt"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			policy, err := parseKeeperPolicy(test.policy, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			groups := findExactDuplicates(writeFiles(t, root, test.files), policy)
+
+			var got [][]string
+			for i, group := range groups {
+				if group.ID != i+1 {
+					t.Errorf("group %d has ID %d", i, group.ID)
+				}
+				members := []string{rel(t, root, group.Members[group.Keeper].Path)}
+				for j, member := range group.Members {
+					if member.Size != group.Size {
+						t.Errorf("%s has size %d in a group of size %d", member.Path, member.Size, group.Size)
+					}
+					if j != group.Keeper {
+						members = append(members, rel(t, root, member.Path))
+					}
+				}
+				got = append(got, members)
+			}
+			if !slices.EqualFunc(got, test.want, slices.Equal[[]string]) {
+				t.Errorf("groups = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// rel returns path relative to root with forward slashes.
+func rel(t *testing.T, root, path string) string {
+	t.Helper()
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/mattanapol/image_manager/internal/csv_helper"
+)
+
+// FolderPair describes how much two folders overlap. FolderA sorts before FolderB.
+type FolderPair struct {
+	FolderA  string
+	FolderB  string
+	ImagesA  int // Images in FolderA
+	ImagesB  int
+	MatchedA int // Images of FolderA with a similar image in FolderB
+	MatchedB int
+	BytesA   int64 // Total size of the images in FolderA
+	BytesB   int64
+}
+
+// PercentAInB is the percentage of the images of FolderA that have a match in FolderB.
+func (p FolderPair) PercentAInB() float64 {
+	return percent(p.MatchedA, p.ImagesA)
+}
+
+// PercentBInA is the percentage of the images of FolderB that have a match in FolderA.
+func (p FolderPair) PercentBInA() float64 {
+	return percent(p.MatchedB, p.ImagesB)
+}
+
+func percent(count, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(count) * 100 / float64(total)
+}
+
+// folderStats are the image count and total size of a folder (not including subfolders).
+type folderStats struct {
+	Images int
+	Bytes  int64
+}
+
+// findFolderPairs aggregates the similar pairs by the folders of their images. A folder whose
+// images all have a match in the other folder (100% A in B) is a redundant copy of it.
+// With -sample only a fraction of the pairs is found, so the percentages are a lower bound.
+func findFolderPairs(files []FileInfo, pairs []SimilarPair) []FolderPair {
+	folders := make(map[string]folderStats)
+	for _, file := range files {
+		stats := folders[filepath.Dir(file.Path)]
+		stats.Images++
+		stats.Bytes += file.Size
+		folders[filepath.Dir(file.Path)] = stats
+	}
+
+	type folderKey struct{ a, b string }
+	// Images of each folder with a match in the other folder of the key, by path
+	matched := make(map[folderKey]map[string]bool)
+	mark := func(key folderKey, path string) {
+		if matched[key] == nil {
+			matched[key] = make(map[string]bool)
+		}
+		matched[key][path] = true
+	}
+	for _, pair := range pairs {
+		dir1, dir2 := filepath.Dir(pair.Path1), filepath.Dir(pair.Path2)
+		if dir1 == dir2 {
+			continue
+		}
+		mark(folderKey{dir1, dir2}, pair.Path1)
+		mark(folderKey{dir2, dir1}, pair.Path2)
+	}
+
+	var folderPairs []FolderPair
+	for key, matchedA := range matched {
+		if key.a > key.b {
+			continue // Reported with the reverse key
+		}
+		statsA, statsB := folders[key.a], folders[key.b]
+		folderPairs = append(folderPairs, FolderPair{
+			FolderA:  key.a,
+			FolderB:  key.b,
+			ImagesA:  statsA.Images,
+			ImagesB:  statsB.Images,
+			MatchedA: len(matchedA),
+			MatchedB: len(matched[folderKey{key.b, key.a}]),
+			BytesA:   statsA.Bytes,
+			BytesB:   statsB.Bytes,
+		})
+	}
+
+	// Most redundant pairs first
+	sort.Slice(folderPairs, func(i, j int) bool {
+		a, b := folderPairs[i], folderPairs[j]
+		if overlapA, overlapB := max(a.PercentAInB(), a.PercentBInA()), max(b.PercentAInB(), b.PercentBInA()); overlapA != overlapB {
+			return overlapA > overlapB
+		}
+		if a.FolderA != b.FolderA {
+			return a.FolderA < b.FolderA
+		}
+		return a.FolderB < b.FolderB
+	})
+	return folderPairs
+}
+
+// printFolderPairs prints the folder pairs where one folder is entirely contained in the other.
+func printFolderPairs(folderPairs []FolderPair) {
+	contained := 0
+	for _, pair := range folderPairs {
+		if pair.PercentAInB() < 100 && pair.PercentBInA() < 100 {
+			continue
+		}
+		contained++
+		fmt.Printf("Folder pair (%.0f%% of A in B, %.0f%% of B in A):\n  A: %s (%d images, %d bytes)\n  B: %s (%d images, %d bytes)\n",
+			pair.PercentAInB(), pair.PercentBInA(), pair.FolderA, pair.ImagesA, pair.BytesA, pair.FolderB, pair.ImagesB, pair.BytesB)
+	}
+	fmt.Printf("Found %d overlapping folder pairs, %d with a folder entirely contained in the other.\n", len(folderPairs), contained)
+}
+
+// writeFolderPairsCSV writes one row per folder pair.
+func writeFolderPairsCSV(filename string, folderPairs []FolderPair) {
+	headers := []string{"folderA", "folderB", "imagesA", "imagesB", "aInB", "bInA", "bytesA", "bytesB", "totalBytes"}
+	csv_helper.CreateCSVFileWithHeaders(filename, headers)
+	for _, pair := range folderPairs {
+		csv_helper.AppendResultToCSV(filename, []string{
+			pair.FolderA,
+			pair.FolderB,
+			strconv.Itoa(pair.ImagesA),
+			strconv.Itoa(pair.ImagesB),
+			fmt.Sprintf("%.1f%%", pair.PercentAInB()),
+			fmt.Sprintf("%.1f%%", pair.PercentBInA()),
+			strconv.FormatInt(pair.BytesA, 10),
+			strconv.FormatInt(pair.BytesB, 10),
+			strconv.FormatInt(pair.BytesA+pair.BytesB, 10),
+		})
+	}
+}
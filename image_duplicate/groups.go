@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mattanapol/image_manager/internal/csv_helper"
+)
+
+// DuplicateGroup is a set of images connected by similar pairs, directly or through other
+// members (a transitive cluster). Members are sorted by path.
+type DuplicateGroup struct {
+	ID      int
+	Members []FileInfo
+	Keeper  int // Index of the suggested keeper in Members, see keeperPolicy
+}
+
+// groupDuplicates clusters the similar pairs into groups with a union-find over the files and
+// picks the keeper of each group with the policy.
+// Groups are numbered from 1 in the order of their first member path, so the same pairs always
+// give the same group IDs.
+func groupDuplicates(files []FileInfo, pairs []SimilarPair, policy keeperPolicy) []DuplicateGroup {
+	index := make(map[string]int, len(files))
+	for i, file := range files {
+		index[file.Path] = i
+	}
+	parent := make([]int, len(files))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for _, pair := range pairs {
+		a, okA := index[pair.Path1]
+		b, okB := index[pair.Path2]
+		if !okA || !okB {
+			continue
+		}
+		if rootA, rootB := find(a), find(b); rootA != rootB {
+			parent[rootB] = rootA
+		}
+	}
+
+	members := make(map[int][]FileInfo)
+	for i, file := range files {
+		root := find(i)
+		members[root] = append(members[root], file)
+	}
+	var groups []DuplicateGroup
+	for _, group := range members {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool { return group[i].Path < group[j].Path })
+		groups = append(groups, DuplicateGroup{Members: group, Keeper: policy.choose(group)})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Members[0].Path < groups[j].Members[0].Path })
+	for i := range groups {
+		groups[i].ID = i + 1
+	}
+	return groups
+}
+
+// similarityToKeeper returns the similarity of a member to the keeper of its group, on the same
+// scale as similarityThreshold.
+func (g DuplicateGroup) similarityToKeeper(member FileInfo) int {
+	distance, err := member.Hash.Distance(g.Members[g.Keeper].Hash)
+	if err != nil {
+		return 0
+	}
+	return 100 - distance
+}
+
+// keeperFirst returns the indexes of the members, the keeper first and the others in path order.
+func (g DuplicateGroup) keeperFirst() []int {
+	order := []int{g.Keeper}
+	for i := range g.Members {
+		if i != g.Keeper {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// printGroups prints every group with its keeper marked.
+func printGroups(groups []DuplicateGroup) {
+	for _, group := range groups {
+		fmt.Printf("Group %d (%d images):\n", group.ID, len(group.Members))
+		for i, member := range group.Members {
+			marker := " "
+			if i == group.Keeper {
+				marker = "*"
+			}
+			fmt.Printf("  %s %s (%dx%d, %d bytes)\n", marker, member.Path, member.Width, member.Height, member.Size)
+		}
+	}
+	fmt.Printf("Found %d duplicate groups (* = suggested keeper).\n", len(groups))
+}
+
+// writeGroupsCSV writes one row per group member, the keeper first. The action column is set to
+// keep for the keepers, the other actions are left to the user, see runApplyCommand.
+func writeGroupsCSV(filename string, groups []DuplicateGroup) {
+	headers := []string{"groupId", "filePath", "keeper", "kind", "similarity", "width", "height", "size", "modTime", "action"}
+	csv_helper.CreateCSVFileWithHeaders(filename, headers)
+	for _, group := range groups {
+		for _, i := range group.keeperFirst() {
+			member := group.Members[i]
+			action := "" // Filled in by the user for the apply command
+			if i == group.Keeper {
+				action = actionKeep
+			}
+			csv_helper.AppendResultToCSV(filename, []string{
+				strconv.Itoa(group.ID),
+				member.Path,
+				strconv.FormatBool(i == group.Keeper),
+				kindSimilar,
+				fmt.Sprintf("%d%%", group.similarityToKeeper(member)),
+				strconv.Itoa(member.Width),
+				strconv.Itoa(member.Height),
+				strconv.FormatInt(member.Size, 10),
+				member.ModTime.Format(time.RFC3339),
+				action,
+			})
+		}
+	}
+}
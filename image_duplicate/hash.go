@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/corona10/goimagehash"
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+	"github.com/mattanapol/image_manager/internal/image_helper"
+)
+
+// hashWidth is the width of the compared hashes, they have hashWidth*hashWidth = hashBits bits.
+const hashWidth = 8
+
+// hashRoots hashes every image under the roots except the paths in skip. Hashes are kept in the
+// cache file of each root, the one image_similar_finder uses for the same folder, so only new or
+// changed images are decoded and a folder hashed by either tool is not hashed again.
+func hashRoots(roots []string, skip map[string]bool) []FileInfo {
+	var files []FileInfo
+	for _, root := range roots {
+		paths, err := hash_helper.FindImages(root, isBlacklisted)
+		if err != nil {
+			fmt.Printf("Error walking directory: %v\n", err)
+			continue
+		}
+		var candidates []string
+		for _, path := range paths {
+			if !skip[path] { // Exact copies are compared through their keeper
+				candidates = append(candidates, path)
+			}
+		}
+
+		cacheFile := filepath.Join(root, hash_helper.DefaultCacheFileName)
+		cache, err := hash_helper.Load(cacheFile, root, candidates)
+		readOnly := errors.Is(err, hash_helper.ErrNewerCacheVersion)
+		if err != nil {
+			fmt.Printf("Warning: Proceeding without cache due to error: %v\n", err)
+			cache = hash_helper.NewCache()
+		}
+		hash_helper.Update(cache.Entries, candidates, numberOfThreads, hasHash, hashFile)
+		if readOnly {
+			fmt.Printf("Warning: Not saving cache file %s, it was written by a newer version\n", cacheFile)
+		} else if err := hash_helper.Save(cacheFile, root, cache); err != nil {
+			fmt.Printf("Warning: Could not save cache file %s: %v\n", cacheFile, err)
+		}
+
+		for _, path := range candidates {
+			if entry := cache.Entries[path]; entry != nil && hasHash(entry) {
+				files = append(files, fileInfo(path, entry))
+			}
+		}
+	}
+	return files
+}
+
+// hasHash reports whether the entry holds the hash of the selected algorithm and the image size.
+func hasHash(entry *hash_helper.Entry) bool {
+	return entry.Hash(hashAlgorithm.Kind, hashBits) != nil && entry.Width > 0
+}
+
+// hashFile decodes an image and computes the hash of the selected algorithm.
+func hashFile(path string) (func(*hash_helper.Entry), error) {
+	img, _, err := image_helper.Decode(path)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hashAlgorithm.Compute(img, hashWidth, hashWidth)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	return func(entry *hash_helper.Entry) {
+		entry.AddHashes([]*goimagehash.ExtImageHash{hash})
+		entry.Width, entry.Height = bounds.Dx(), bounds.Dy()
+	}, nil
+}
+
+// fileInfo converts a cache entry to the FileInfo of an image.
+func fileInfo(path string, entry *hash_helper.Entry) FileInfo {
+	hash := entry.Hash(hashAlgorithm.Kind, hashBits)
+	return FileInfo{
+		Path:    path,
+		Hash:    goimagehash.NewImageHash(hash.GetHash()[0], hashAlgorithm.Kind),
+		Width:   entry.Width,
+		Height:  entry.Height,
+		Size:    entry.Size,
+		ModTime: time.Unix(0, entry.ModTime),
+	}
+}
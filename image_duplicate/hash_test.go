@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+)
+
+// writePNG writes a small image whose pattern depends on seed.
+func writePNG(t *testing.T, path string, seed int) {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 32, 32))
+	for y := range 32 {
+		for x := range 32 {
+			img.SetGray(x, y, color.Gray{Y: uint8(x*seed + y*(seed/3+1))})
+		}
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHashRootsHashesEveryImageOnce(t *testing.T) {
+	numberOfThreads = 8
+	hashAlgorithm = hash_helper.Algorithms["perception"]
+	blacklist = nil
+
+	root := t.TempDir()
+	want := make(map[string]bool)
+	for i := range 6 {
+		dir := root
+		for j := range i {
+			dir = filepath.Join(dir, fmt.Sprintf("level%d", j))
+		}
+		for k := range 5 {
+			sub := filepath.Join(dir, fmt.Sprintf("sub%d", k))
+			if err := os.MkdirAll(sub, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(sub, fmt.Sprintf("img%d.png", k))
+			writePNG(t, path, i*5+k+1)
+			want[path] = true
+		}
+	}
+
+	for run := range 2 { // The second run reads the hashes from the cache
+		files := hashRoots([]string{root}, nil)
+		seen := make(map[string]int)
+		for _, file := range files {
+			seen[file.Path]++
+			if file.Hash == nil || file.Width != 32 {
+				t.Errorf("run %d: %s has hash %v and width %d", run, file.Path, file.Hash, file.Width)
+			}
+		}
+		for path := range want {
+			if seen[path] != 1 {
+				t.Errorf("run %d: %s returned %d times, want 1", run, path, seen[path])
+			}
+		}
+		if len(seen) != len(want) {
+			t.Errorf("run %d: returned %d images, want %d", run, len(seen), len(want))
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+// hashBits is the size in bits of the hash of hashAlgorithm compared by the tool, see hashWidth.
+const hashBits = 64
+
+// minChunkBits is the narrowest range of bits worth bucketing hashes by. Ranges of 1 or 2 bits
+// only have 2 or 4 values, so most pairs share a bucket of one of the many ranges and comparing
+// every pair once is faster.
+const minChunkBits = 3
+
+// SimilarPair is a pair of images whose hashes are within the similarity threshold.
+// Path1 sorts before Path2.
+type SimilarPair struct {
+	Path1      string
+	Path2      string
+	Similarity int
+}
+
+// hashChunk is a range of bits of a hash.
+type hashChunk struct {
+	shift uint
+	mask  uint64
+}
+
+// hashChunks splits a hash into maxDistance+1 ranges of (almost) equal width. Two hashes that
+// differ in at most maxDistance bits leave at least one of the ranges untouched (pigeonhole),
+// so only hashes that share the value of a range have to be compared.
+// Past minChunkBits it returns a single empty range, which buckets every hash together so every
+// pair is compared once.
+func hashChunks(maxDistance int) []hashChunk {
+	if maxDistance >= hashBits || hashBits/(maxDistance+1) < minChunkBits {
+		return []hashChunk{{}}
+	}
+	count := maxDistance + 1
+	chunks := make([]hashChunk, 0, count)
+	shift := uint(0)
+	for i := 0; i < count; i++ {
+		width := uint(hashBits / count)
+		if i < hashBits%count {
+			width++
+		}
+		mask := uint64(1)<<width - 1
+		if width == hashBits {
+			mask = ^uint64(0)
+		}
+		chunks = append(chunks, hashChunk{shift: shift, mask: mask})
+		shift += width
+	}
+	return chunks
+}
+
+// value returns the bits of the hash in the range.
+func (c hashChunk) value(hash uint64) uint64 {
+	return hash >> c.shift & c.mask
+}
+
+// findSimilarPairs compares every image with every other one and returns all pairs within
+// maxDistance, sorted by path. Hashes are bucketed by each of their hashChunks (multi-index
+// hashing), so only images sharing a bucket are compared instead of all n*(n-1)/2 pairs.
+// The result only depends on the files, not on the order they were found in.
+func findSimilarPairs(files []FileInfo, maxDistance int) []SimilarPair {
+	sorted := make([]FileInfo, len(files))
+	copy(sorted, files)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+	hashes := make([]uint64, len(sorted))
+	for i, file := range sorted {
+		hashes[i] = file.Hash.GetHash()
+	}
+
+	chunks := hashChunks(maxDistance)
+	var pairs []SimilarPair
+	compared := 0
+	for c, chunk := range chunks {
+		buckets := make(map[uint64][]int)
+		for i, hash := range hashes {
+			buckets[chunk.value(hash)] = append(buckets[chunk.value(hash)], i)
+		}
+		for _, bucket := range buckets {
+			for a := 0; a < len(bucket); a++ {
+				for b := a + 1; b < len(bucket); b++ {
+					i, j := bucket[a], bucket[b]
+					if sharesEarlierChunk(chunks[:c], hashes[i], hashes[j]) {
+						continue // Already compared in the bucket of that chunk
+					}
+					compared++
+					distance := bits.OnesCount64(hashes[i] ^ hashes[j])
+					if distance <= maxDistance {
+						pairs = append(pairs, SimilarPair{Path1: sorted[i].Path, Path2: sorted[j].Path, Similarity: 100 - distance})
+					}
+				}
+			}
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Path1 != pairs[j].Path1 {
+			return pairs[i].Path1 < pairs[j].Path1
+		}
+		return pairs[i].Path2 < pairs[j].Path2
+	})
+	fmt.Printf("Compared %d of %d possible pairs.\n", compared, len(files)*(len(files)-1)/2)
+	return pairs
+}
+
+// sharesEarlierChunk reports whether two hashes have the same value in one of the chunks.
+func sharesEarlierChunk(chunks []hashChunk, a, b uint64) bool {
+	for _, chunk := range chunks {
+		if chunk.value(a) == chunk.value(b) {
+			return true
+		}
+	}
+	return false
+}
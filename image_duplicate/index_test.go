@@ -0,0 +1,72 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/corona10/goimagehash"
+)
+
+func TestFindSimilarPairsMatchesBruteForce(t *testing.T) {
+	// Clusters of hashes a few bits apart, so every threshold finds some pairs and misses others
+	random := rand.New(rand.NewSource(1))
+	var files []FileInfo
+	for cluster := range 8 {
+		base := random.Uint64()
+		for member := range 12 {
+			hash := base
+			for range random.Intn(member*3 + 1) {
+				hash ^= 1 << random.Intn(hashBits)
+			}
+			files = append(files, FileInfo{
+				Path: fmt.Sprintf("%d/%02d.jpg", cluster, member),
+				Hash: goimagehash.NewImageHash(hash, goimagehash.PHash),
+			})
+		}
+	}
+	random.Shuffle(len(files), func(i, j int) { files[i], files[j] = files[j], files[i] })
+
+	tests := []struct {
+		maxDistance int
+		chunks      int
+	}{
+		{maxDistance: 0, chunks: 1},
+		{maxDistance: 1, chunks: 2},
+		{maxDistance: 5, chunks: 6},
+		{maxDistance: 10, chunks: 11},
+		{maxDistance: 20, chunks: 21}, // Ranges of 3 bits
+		{maxDistance: 21, chunks: 1},  // Ranges of 2 bits, compared by brute force
+		{maxDistance: 40, chunks: 1},  // Ranges of 1 bit
+		{maxDistance: 64, chunks: 1},  // Every pair
+		{maxDistance: 100, chunks: 1}, // Every pair
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.maxDistance), func(t *testing.T) {
+			if chunks := len(hashChunks(test.maxDistance)); chunks != test.chunks {
+				t.Errorf("hashChunks(%d) has %d chunks, want %d", test.maxDistance, chunks, test.chunks)
+			}
+			var want []SimilarPair
+			for i := range files {
+				for j := range files {
+					a, b := files[i], files[j]
+					if a.Path >= b.Path {
+						continue
+					}
+					if distance := bits.OnesCount64(a.Hash.GetHash() ^ b.Hash.GetHash()); distance <= test.maxDistance {
+						want = append(want, SimilarPair{Path1: a.Path, Path2: b.Path, Similarity: 100 - distance})
+					}
+				}
+			}
+			slices.SortFunc(want, func(a, b SimilarPair) int {
+				return cmp.Or(cmp.Compare(a.Path1, b.Path1), cmp.Compare(a.Path2, b.Path2))
+			})
+			if got := findSimilarPairs(files, test.maxDistance); !slices.Equal(got, want) {
+				t.Errorf("findSimilarPairs found %d pairs, brute force %d", len(got), len(want))
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattanapol/image_manager/internal/image_helper"
+)
+
+// defaultKeeperPolicy keeps the highest resolution, then the largest file, then the oldest one
+// and finally the shortest path.
+const defaultKeeperPolicy = "resolution,size,oldest,path"
+
+// keeperCandidate is a group member with the date used by the oldest rule.
+type keeperCandidate struct {
+	FileInfo
+	Date time.Time // EXIF date taken, or the modification time without one
+}
+
+// keeperRule compares two candidates: negative when a is the better keeper, positive when b is
+// and 0 when the rule cannot tell them apart.
+type keeperRule func(a, b keeperCandidate) int
+
+// keeperPolicy picks the keeper of a group by applying its rules in order until one of them
+// prefers a member.
+type keeperPolicy struct {
+	rules    []keeperRule
+	useDates bool // Whether a rule needs the EXIF dates
+}
+
+// parseKeeperPolicy parses a comma separated list of rules: resolution (highest first), size
+// (largest first), oldest (EXIF date taken, else modification time), path (shortest first) and
+// root (files under preferredRoot first).
+func parseKeeperPolicy(spec, preferredRoot string) (keeperPolicy, error) {
+	var policy keeperPolicy
+	for _, name := range strings.Split(spec, ",") {
+		switch strings.TrimSpace(name) {
+		case "resolution":
+			policy.rules = append(policy.rules, byResolution)
+		case "size":
+			policy.rules = append(policy.rules, bySize)
+		case "oldest":
+			policy.rules = append(policy.rules, byOldest)
+			policy.useDates = true
+		case "path":
+			policy.rules = append(policy.rules, byShortestPath)
+		case "root":
+			if preferredRoot == "" {
+				return keeperPolicy{}, fmt.Errorf("the root rule needs a preferred root (-prefer-root)")
+			}
+			policy.rules = append(policy.rules, underRoot(preferredRoot))
+		default:
+			return keeperPolicy{}, fmt.Errorf("unknown keeper rule %q (expected resolution, size, oldest, path or root)", name)
+		}
+	}
+	return policy, nil
+}
+
+// choose returns the index of the member to keep. Members every rule ties on are decided by
+// path so the choice is deterministic.
+func (p keeperPolicy) choose(members []FileInfo) int {
+	candidates := make([]keeperCandidate, len(members))
+	for i, member := range members {
+		candidates[i] = keeperCandidate{FileInfo: member, Date: member.ModTime}
+		if p.useDates {
+			if taken, err := image_helper.DateTaken(member.Path); err == nil {
+				candidates[i].Date = taken
+			}
+		}
+	}
+
+	best := 0
+	for i := 1; i < len(candidates); i++ {
+		if p.compare(candidates[i], candidates[best]) < 0 {
+			best = i
+		}
+	}
+	return best
+}
+
+func (p keeperPolicy) compare(a, b keeperCandidate) int {
+	for _, rule := range p.rules {
+		if order := rule(a, b); order != 0 {
+			return order
+		}
+	}
+	return strings.Compare(a.Path, b.Path)
+}
+
+func byResolution(a, b keeperCandidate) int {
+	return b.Width*b.Height - a.Width*a.Height
+}
+
+func bySize(a, b keeperCandidate) int {
+	return cmp.Compare(b.Size, a.Size)
+}
+
+func byOldest(a, b keeperCandidate) int {
+	return a.Date.Compare(b.Date)
+}
+
+func byShortestPath(a, b keeperCandidate) int {
+	return len(a.Path) - len(b.Path)
+}
+
+// underRoot prefers the files inside root. Both are compared as absolute paths, the walked paths
+// are relative when the scanned root is.
+func underRoot(root string) keeperRule {
+	return func(a, b keeperCandidate) int {
+		switch insideA, insideB := isInside(a.Path, root), isInside(b.Path, root); {
+		case insideA && !insideB:
+			return -1
+		case insideB && !insideA:
+			return 1
+		}
+		return 0
+	}
+}
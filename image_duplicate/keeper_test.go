@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRootRuleComparesAbsolutePaths(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// Scanned with -root lib, so the walked paths are relative
+	members := []FileInfo{{Path: filepath.Join("lib", "A", "b.png")}, {Path: filepath.Join("lib", "C", "b.png")}}
+	tests := []struct {
+		preferredRoot string
+		want          int
+	}{
+		{preferredRoot: filepath.Join(dir, "lib", "C"), want: 1},
+		{preferredRoot: filepath.Join("lib", "C"), want: 1},
+		{preferredRoot: filepath.Join("lib", "C") + string(filepath.Separator), want: 1},
+		{preferredRoot: filepath.Join(dir, "lib", "A"), want: 0},
+		{preferredRoot: filepath.Join(dir, "lib", "C", "b"), want: 0}, // Not a folder of either, the path rule decides
+	}
+	for _, test := range tests {
+		policy, err := parseKeeperPolicy("root,path", test.preferredRoot)
+		if err != nil {
+			t.Fatalf("parseKeeperPolicy: %v", err)
+		}
+		if got := policy.choose(members); got != test.want {
+			t.Errorf("with -prefer-root %s, keeper = %s, want %s", test.preferredRoot, members[got].Path, members[test.want].Path)
+		}
+	}
+}
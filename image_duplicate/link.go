@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/mattanapol/image_manager/internal/file_helper"
+)
+
+// Modes of -link
+const (
+	linkHardlink = "hardlink"
+	linkReflink  = "reflink"
+)
+
+// linkExactCopies replaces the copies of every exact group with links to their keeper, so their
+// space is reclaimed while every path still opens the same content, see linkGroups.
+func linkExactCopies(groups []ExactGroup, mode string) {
+	replace := file_helper.ReplaceWithHardlink
+	if mode == linkReflink {
+		replace = file_helper.ReplaceWithClone
+	}
+	linkGroups(groups, mode, replace)
+}
+
+// linkGroups replaces the copies of the groups with replace, which compares each copy with its
+// source byte by byte first. A copy on another device than the keeper is linked to a copy on its
+// own device instead, the first such copy is left as it is.
+func linkGroups(groups []ExactGroup, mode string, replace func(path, target string) error) {
+	linked, alreadyLinked, kept := 0, 0, 0
+	var savedBytes int64
+groups:
+	for _, group := range groups {
+		// Files the copies can be linked to, the keeper first and then one copy per other device
+		sources := []string{group.Members[group.Keeper].Path}
+		for i, member := range group.Members {
+			if i == group.Keeper {
+				continue
+			}
+			if slices.ContainsFunc(sources, func(source string) bool { return isSameFile(member.Path, source) }) {
+				alreadyLinked++
+				continue
+			}
+			err := file_helper.ErrCrossDevice
+			for _, source := range sources {
+				if err = replace(member.Path, source); !errors.Is(err, file_helper.ErrCrossDevice) {
+					break
+				}
+			}
+			switch {
+			case err == nil:
+				linked++
+				savedBytes += group.Size
+			case errors.Is(err, file_helper.ErrCrossDevice):
+				// Nothing to link to on this device yet, the next copies there can link to it
+				sources = append(sources, member.Path)
+				kept++
+			case errors.Is(err, file_helper.ErrCloneNotSupported):
+				fmt.Printf("Error linking %s: %v\n", member.Path, err)
+				fmt.Println("Use -link hardlink on file systems without reflink support.")
+				break groups
+			default:
+				fmt.Printf("Error linking %s: %v\n", member.Path, err)
+			}
+		}
+	}
+	fmt.Printf("Replaced %d exact copies with %ss, saving %.2f MB.\n", linked, mode, float64(savedBytes)/(1024*1024))
+	if alreadyLinked > 0 {
+		fmt.Printf("%d copies were already hardlinked.\n", alreadyLinked)
+	}
+	if kept > 0 {
+		fmt.Printf("%d copies are the first on another device than their keeper and were left as they are.\n", kept)
+	}
+}
+
+// isSameFile reports whether both paths are the same file, e.g. hardlinks of each other.
+func isSameFile(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mattanapol/image_manager/internal/file_helper"
+)
+
+func TestLinkGroupsCrossDeviceFallback(t *testing.T) {
+	root := t.TempDir()
+	entries := writeFiles(t, root, []testFile{
+		{name: "one/keeper.jpg", content: "same"},
+		{name: "one/copy.jpg", content: "same"},
+		{name: "two/first.jpg", content: "same"},
+		{name: "two/second.jpg", content: "same"},
+		{name: "two/third.jpg", content: "same"},
+	})
+	keeper, dup, first, second, third := entries[0].Path, entries[1].Path, entries[2].Path, entries[3].Path, entries[4].Path
+	// Folders one and two stand for two devices
+	device := func(path string) string { return filepath.Base(filepath.Dir(path)) }
+	replace := func(path, target string) error {
+		if device(path) != device(target) {
+			return file_helper.ErrCrossDevice
+		}
+		return file_helper.ReplaceWithHardlink(path, target)
+	}
+	groups := []ExactGroup{{ID: 1, Size: 4, Members: entries}}
+
+	for run := range 2 { // The second run finds every copy already linked
+		linkGroups(groups, linkHardlink, replace)
+		if !isSameFile(dup, keeper) {
+			t.Errorf("run %d: %s is not linked to the keeper", run, dup)
+		}
+		if isSameFile(first, keeper) {
+			t.Errorf("run %d: %s is linked across devices", run, first)
+		}
+		for _, path := range []string{second, third} {
+			if !isSameFile(path, first) {
+				t.Errorf("run %d: %s is not linked to the first copy on its device", run, path)
+			}
+		}
+		for _, entry := range entries {
+			if content, err := os.ReadFile(entry.Path); err != nil || string(content) != "same" {
+				t.Errorf("run %d: %s = %q, %v", run, entry.Path, content, err)
+			}
+		}
+	}
+}
+
+func TestMediaGroups(t *testing.T) {
+	groups := []ExactGroup{
+		{ID: 1, Members: []FileEntry{{Path: "a.jpg"}, {Path: "b.JPG"}}},
+		{ID: 2, Members: []FileEntry{{Path: "notes.txt"}, {Path: "copy.txt"}}},
+		{ID: 3, Members: []FileEntry{{Path: "clip.MOV"}, {Path: "clip copy.mov"}}},
+		{ID: 4, Members: []FileEntry{{Path: "archive.zip"}, {Path: "photo.png"}}}, // Same content, one with an image extension
+	}
+	var paths []string
+	for _, group := range mediaGroups(groups) {
+		paths = append(paths, group.Members[0].Path)
+	}
+	if got, want := strings.Join(paths, ","), "a.jpg,clip.MOV,archive.zip"; got != want {
+		t.Errorf("mediaGroups = %s, want %s", got, want)
+	}
+}
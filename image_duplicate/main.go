@@ -1,113 +1,134 @@
 package main
 
 import (
-	"encoding/csv"
 	"fmt"
 	"math/rand"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/corona10/goimagehash"
-	"github.com/disintegration/imaging"
-	"github.com/mattanapol/image_manager/internal/csv_helper"
+	"github.com/mattanapol/image_manager/internal/hash_helper"
 )
 
 const (
-	similarityThreshold = 99
-	gcInterval          = 100
-	hashSavePerFolder   = 5
-	percentSave         = 10
+	hashSavePerFolder = 5
+	percentSave       = 10
+	outputFile        = "results.csv"
+	exactFile         = "exact.csv"
+	folderFile        = "folders.csv"
 )
 
+// Settings of the run, see config
 var (
-	rootFolder      = "/Volumes/CRUCIALSSD"
-	numberOfThreads = 2
-	outputFile      = "./results.csv"
-	blacklist       = []string{"$RECYCLE.BIN"}
+	numberOfThreads     int
+	similarityThreshold int
+	blacklist           []string
+	hashAlgorithm       hash_helper.Algorithm
 )
 
 type FileInfo struct {
-	Path string
-	Hash *goimagehash.ImageHash
+	Path    string
+	Hash    *goimagehash.ImageHash
+	Width   int
+	Height  int
+	Size    int64
+	ModTime time.Time
 }
 
 func main() {
-	start := time.Now()
-
-	fileInfos := make(chan FileInfo)
-	wg := &sync.WaitGroup{}
-
-	wg.Add(1)
-	go func() {
-		walkDirectory(rootFolder, fileInfos, wg)
-		wg.Wait()
-		close(fileInfos)
-	}()
-
-	compareFiles(fileInfos)
-
-	elapsed := time.Since(start)
-	fmt.Printf("Elapsed time: %s\n", elapsed)
-}
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "restore":
+			runRestoreCommand(os.Args[2:])
+			return
+		case "review":
+			runReviewCommand(os.Args[2:])
+			return
+		case "apply":
+			runApplyCommand(os.Args[2:])
+			return
+		}
+	}
 
-func walkDirectory(root string, fileInfos chan<- FileInfo, wg *sync.WaitGroup) {
-	defer wg.Done()
+	cfg, err := parseConfig(os.Args[1:])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(2)
+	}
+	policy, err := parseKeeperPolicy(cfg.Keep, cfg.PreferRoot)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(2)
+	}
+	if err := os.MkdirAll(cfg.Output, 0755); err != nil {
+		fmt.Printf("Error creating output folder: %v\n", err)
+		os.Exit(1)
+	}
+	numberOfThreads = cfg.Threads
+	similarityThreshold = cfg.Threshold
+	blacklist = cfg.Skip
+	hashAlgorithm = hash_helper.Algorithms[cfg.Algorithm]
 
-	semaphore := make(chan struct{}, numberOfThreads)
-	fileCounter := 0
+	start := time.Now()
 
-	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			fmt.Printf("Error accessing path %q: %v\n", path, err)
-			return err
-		}
+	// --- Exact duplicates of any file type ---
+	outputFolders := []string{cfg.Output}
+	if cfg.Quarantine != "" {
+		outputFolders = append(outputFolders, cfg.Quarantine)
+	}
+	var entries []FileEntry
+	for _, root := range cfg.Roots {
+		entries = append(entries, listFiles(root, outputFolders)...)
+	}
+	exactGroups := findExactDuplicates(entries, policy)
+	printExactGroups(exactGroups)
+	writeExactGroupsCSV(filepath.Join(cfg.Output, exactFile), exactGroups)
+	if cfg.Link != "" {
+		linkExactCopies(mediaGroups(exactGroups), cfg.Link)
+	}
 
-		if isBlacklisted(path) {
-			if d.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
+	// --- Perceptual comparison of the remaining images ---
+	skip := exactCopies(exactGroups)
+	fileInfos := hashRoots(cfg.Roots, skip)
 
-		if d.IsDir() {
-			if path != root {
-				wg.Add(1)
-				go walkDirectory(path, fileInfos, wg)
-				return filepath.SkipDir
-			}
-			return nil
-		}
+	var files []FileInfo
+	var pairs []SimilarPair
+	if cfg.Sample {
+		files, pairs = compareFiles(fileInfos)
+	} else {
+		files, pairs = compareAllFiles(fileInfos)
+	}
 
-		semaphore <- struct{}{}
-		go func() {
-			defer func() { <-semaphore }()
-			img, err := imaging.Open(path)
-			if err != nil {
-				return
-			}
+	groups := groupDuplicates(files, pairs, policy)
+	printGroups(groups)
+	writeGroupsCSV(filepath.Join(cfg.Output, outputFile), groups)
+	if cfg.HTML {
+		writeHTMLReport(filepath.Join(cfg.Output, reportFile), mediaGroups(exactGroups), groups)
+	}
 
-			hash, err := goimagehash.AverageHash(img)
-			if err != nil {
-				return
-			}
+	folderPairs := findFolderPairs(withExactCopies(files, pairs, exactGroups))
+	printFolderPairs(folderPairs)
+	writeFolderPairsCSV(filepath.Join(cfg.Output, folderFile), folderPairs)
 
-			fileInfos <- FileInfo{Path: path, Hash: hash}
+	if cfg.Quarantine != "" {
+		quarantineFiles(cfg.Quarantine, quarantineMoves(exactGroups, groups))
+	}
 
-			fileCounter++
-			if fileCounter >= gcInterval {
-				runtime.GC() // Trigger garbage collection
-			}
-		}()
-		return nil
-	})
+	elapsed := time.Since(start)
+	fmt.Printf("Elapsed time: %s\n", elapsed)
+}
 
-	if err != nil {
-		fmt.Printf("Error walking directory: %v\n", err)
+// isInside reports whether path is folder or inside it.
+func isInside(path, folder string) bool {
+	absPath, errPath := filepath.Abs(path)
+	absFolder, errFolder := filepath.Abs(folder)
+	if errPath != nil || errFolder != nil {
+		return false
 	}
+	rel, err := filepath.Rel(absFolder, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
 }
 
 func isBlacklisted(path string) bool {
@@ -118,16 +139,26 @@ func isBlacklisted(path string) bool {
 	}
 	return false
 }
-func compareFiles(fileInfos <-chan FileInfo) {
+
+// compareAllFiles returns every similar pair of images, see findSimilarPairs.
+func compareAllFiles(files []FileInfo) ([]FileInfo, []SimilarPair) {
+	fmt.Printf("Hashed %d images, comparing...\n", len(files))
+
+	// Largest hash distance that reaches similarityThreshold
+	pairs := findSimilarPairs(files, 100-similarityThreshold)
+	fmt.Printf("Found %d similar pairs.\n", len(pairs))
+	return files, pairs
+}
+
+// compareFiles compares every image against a random sample of the images before it, skipping
+// pairs in the same folder and folders that already have a match. Used with -sample.
+func compareFiles(fileInfos []FileInfo) ([]FileInfo, []SimilarPair) {
 	processed := make(map[string]*goimagehash.ImageHash)
 	processedFolders := make(map[string]map[string]bool)
 	processedHashFolderCount := make(map[string]int)
 
-	// Create the CSV file and write the headers
-	headers := []string{"filePath1", "filePath2", "similarity"}
-	csv_helper.CreateCSVFileWithHeaders(outputFile, headers)
-
-	for fileInfo := range fileInfos {
+	var pairs []SimilarPair
+	for _, fileInfo := range fileInfos {
 		fileDir := filepath.Dir(fileInfo.Path)
 
 		for path, hash := range processed {
@@ -151,9 +182,7 @@ func compareFiles(fileInfos <-chan FileInfo) {
 			similarity := 100 - distance
 			if similarity >= similarityThreshold {
 				fmt.Printf("Found similar files:\n%s\n%s\nSimilarity: %d%%\n", path, fileInfo.Path, similarity)
-				result := []string{path, fileInfo.Path, fmt.Sprintf("%d%%", similarity)}
-
-				csv_helper.AppendResultToCSV(outputFile, result)
+				pairs = append(pairs, SimilarPair{Path1: min(path, fileInfo.Path), Path2: max(path, fileInfo.Path), Similarity: similarity})
 
 				if _, ok := processedFolders[fileDir]; !ok {
 					processedFolders[fileDir] = make(map[string]bool)
@@ -171,45 +200,9 @@ func compareFiles(fileInfos <-chan FileInfo) {
 			processed[fileInfo.Path] = fileInfo.Hash
 		}
 	}
+	return fileInfos, pairs
 }
 
 func tossACoin(percent int) bool {
 	return rand.Intn(100/percent) == 0
 }
-
-func createCSVFileWithHeaders(filename string) {
-	file, err := os.Create(filename)
-	if err != nil {
-		fmt.Printf("Error creating CSV file: %v\n", err)
-		return
-	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	headers := []string{"filePath1", "filePath2", "similarity"}
-	err = writer.Write(headers)
-	if err != nil {
-		fmt.Printf("Error writing CSV header: %v\n", err)
-		return
-	}
-}
-
-func appendResultToCSV(filename string, record []string) {
-	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Printf("Error opening CSV file for appending: %v\n", err)
-		return
-	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	err = writer.Write(record)
-	if err != nil {
-		fmt.Printf("Error writing CSV record: %v\n", err)
-		return
-	}
-}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mattanapol/image_manager/internal/csv_helper"
+	"github.com/mattanapol/image_manager/internal/file_helper"
+)
+
+// manifestFileName is the manifest written in the quarantine directory. Runs append to it, so it
+// lists every file ever quarantined there that has not been restored.
+const manifestFileName = "manifest.csv"
+
+var manifestHeaders = []string{"originalPath", "quarantinePath", "kind", "groupId", "keeperPath"}
+
+// Kinds of duplicates, recorded in the kind column of the results and of the manifest
+const (
+	kindExact   = "exact"
+	kindSimilar = "similar"
+)
+
+// quarantineMove is a file to move out of the way, with the keeper it duplicates.
+type quarantineMove struct {
+	Path    string
+	Keeper  string
+	Kind    string // kindExact or kindSimilar
+	GroupID int
+}
+
+// quarantineMoves lists the non-keepers of every group: first the exact copies of images and
+// videos (other exact duplicates are only reported, see mediaGroups), then the similar images. An
+// exact copy whose keeper is itself a similar non-keeper duplicates the keeper of that similar
+// group instead.
+func quarantineMoves(exactGroups []ExactGroup, groups []DuplicateGroup) []quarantineMove {
+	var similar []quarantineMove
+	replacedBy := make(map[string]string)
+	for _, group := range groups {
+		for i, member := range group.Members {
+			if i != group.Keeper {
+				similar = append(similar, quarantineMove{Path: member.Path, Keeper: group.Members[group.Keeper].Path, Kind: kindSimilar, GroupID: group.ID})
+				replacedBy[member.Path] = group.Members[group.Keeper].Path
+			}
+		}
+	}
+
+	var moves []quarantineMove
+	for _, group := range mediaGroups(exactGroups) {
+		keeper := group.Members[group.Keeper].Path
+		if replacement, exists := replacedBy[keeper]; exists {
+			keeper = replacement
+		}
+		for i, member := range group.Members {
+			if i != group.Keeper {
+				moves = append(moves, quarantineMove{Path: member.Path, Keeper: keeper, Kind: kindExact, GroupID: group.ID})
+			}
+		}
+	}
+	return append(moves, similar...)
+}
+
+// quarantinePath mirrors the absolute path of a file inside the quarantine directory.
+func quarantinePath(dir, path string) (string, error) {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strings.TrimPrefix(absolute, filepath.VolumeName(absolute))), nil
+}
+
+// quarantineFiles moves the files into dir, mirroring their original paths, and records every
+// move in the manifest as soon as it is done. A file is only moved while its keeper still exists.
+func quarantineFiles(dir string, moves []quarantineMove) {
+	manifest, err := openManifest(dir)
+	if err != nil {
+		fmt.Printf("Error creating quarantine directory: %v\n", err)
+		return
+	}
+
+	moved := 0
+	for _, move := range moves {
+		if _, err := os.Stat(move.Keeper); err != nil {
+			fmt.Printf("Skipping %s, its keeper is missing: %v\n", move.Path, err)
+			continue
+		}
+		if _, err := quarantineFile(dir, manifest, move); err != nil {
+			fmt.Printf("Error quarantining %s: %v\n", move.Path, err)
+			continue
+		}
+		moved++
+	}
+	fmt.Printf("Moved %d of %d duplicates to %s, restore them with: image_duplicate restore %s\n", moved, len(moves), dir, dir)
+}
+
+// openManifest creates the quarantine directory and its manifest, if missing, and returns the
+// path of the manifest.
+func openManifest(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	manifest := filepath.Join(dir, manifestFileName)
+	if _, err := os.Stat(manifest); os.IsNotExist(err) {
+		csv_helper.CreateCSVFileWithHeaders(manifest, manifestHeaders)
+	}
+	return manifest, nil
+}
+
+// quarantineFile moves one file into dir and records the move in the manifest. It returns the
+// path the file was moved to.
+func quarantineFile(dir, manifest string, move quarantineMove) (string, error) {
+	target, err := quarantinePath(dir, move.Path)
+	if err == nil {
+		target, err = file_helper.GetNextAvailableFilePath(target)
+	}
+	if err == nil {
+		err = file_helper.MoveFile(move.Path, target)
+	}
+	if err != nil {
+		return "", err
+	}
+	csv_helper.AppendResultToCSV(manifest, []string{move.Path, target, move.Kind, strconv.Itoa(move.GroupID), move.Keeper})
+	return target, nil
+}
+
+// runRestoreCommand moves the files listed in the manifest of a quarantine directory back to
+// their original path. Files whose original path is taken again are left in quarantine, the
+// manifest is rewritten with them.
+func runRestoreCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: image_duplicate restore <quarantine directory>")
+		os.Exit(2)
+	}
+	if err := restoreFiles(args[0]); err != nil {
+		fmt.Printf("Error reading manifest: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// restoreFiles restores the files of the quarantine directory dir, see runRestoreCommand. It only
+// returns an error when the manifest cannot be read.
+func restoreFiles(dir string) error {
+	manifest := filepath.Join(dir, manifestFileName)
+	records, err := readManifest(manifest)
+	if err != nil {
+		return err
+	}
+
+	var remaining [][]string
+	restored := 0
+	for _, record := range records {
+		original, quarantined := record[0], record[1]
+		if _, err := os.Lstat(original); err == nil {
+			fmt.Printf("Not restoring %s, the path exists again\n", original)
+			remaining = append(remaining, record)
+			continue
+		}
+		if err := file_helper.MoveFile(quarantined, original); err != nil {
+			fmt.Printf("Error restoring %s: %v\n", original, err)
+			remaining = append(remaining, record)
+			continue
+		}
+		restored++
+	}
+
+	csv_helper.CreateCSVFileWithHeaders(manifest, manifestHeaders)
+	for _, record := range remaining {
+		csv_helper.AppendResultToCSV(manifest, record)
+	}
+	fmt.Printf("Restored %d of %d files, %d left in quarantine.\n", restored, len(records), len(remaining))
+	return nil
+}
+
+// readManifest returns the records of a manifest without its header.
+func readManifest(filename string) ([][]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = len(manifestHeaders)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s is empty", filename)
+	}
+	return records[1:], nil
+}
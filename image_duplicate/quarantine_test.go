@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestQuarantineMovesOnlyMedia(t *testing.T) {
+	root := t.TempDir()
+	entries := writeFiles(t, root, []testFile{
+		{name: "photo.jpg", content: "photo"},
+		{name: "copy/photo.jpg", content: "photo"},
+		{name: "clip.mp4", content: "video"},
+		{name: "copy/clip.mp4", content: "video"},
+		{name: "notes.txt", content: "notes"},
+		{name: "copy/notes.txt", content: "notes"},
+	})
+	exactGroups := []ExactGroup{
+		{ID: 1, Members: []FileEntry{entries[0], entries[1]}},
+		{ID: 2, Members: []FileEntry{entries[2], entries[3]}, Keeper: 1},
+		{ID: 3, Members: []FileEntry{entries[4], entries[5]}},
+	}
+	similar := filepath.Join(root, "similar.jpg")
+	groups := []DuplicateGroup{{ID: 1, Members: []FileInfo{{Path: entries[0].Path}, {Path: similar}}}}
+
+	want := []quarantineMove{
+		{Path: entries[1].Path, Keeper: entries[0].Path, Kind: kindExact, GroupID: 1},
+		{Path: entries[2].Path, Keeper: entries[3].Path, Kind: kindExact, GroupID: 2},
+		{Path: similar, Keeper: entries[0].Path, Kind: kindSimilar, GroupID: 1},
+	}
+	if got := quarantineMoves(exactGroups, groups); !slices.Equal(got, want) {
+		t.Errorf("quarantineMoves = %+v, want %+v", got, want)
+	}
+}
+
+func TestQuarantineRestoreRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(t.TempDir(), "quarantine")
+	files := []testFile{
+		{name: "keeper.jpg", content: "same"},
+		{name: "a/copy.jpg", content: "same"},
+		{name: "b/similar.jpg", content: "similar"},
+		{name: "c/copy.jpg", content: "same"},
+	}
+	entries := writeFiles(t, root, files)
+	keeper := entries[0].Path
+	moves := []quarantineMove{
+		{Path: entries[1].Path, Keeper: keeper, Kind: kindExact, GroupID: 1},
+		{Path: entries[2].Path, Keeper: keeper, Kind: kindSimilar, GroupID: 2},
+		{Path: entries[3].Path, Keeper: keeper, Kind: kindExact, GroupID: 1},
+		{Path: filepath.Join(root, "missing keeper.jpg"), Keeper: filepath.Join(root, "gone.jpg"), Kind: kindSimilar, GroupID: 3},
+	}
+	quarantineFiles(dir, moves)
+
+	records, err := readManifest(filepath.Join(dir, manifestFileName))
+	if err != nil {
+		t.Fatalf("readManifest: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("manifest has %d records, want 3", len(records))
+	}
+	for i, record := range records {
+		if record[0] != moves[i].Path || record[2] != moves[i].Kind || record[4] != keeper {
+			t.Errorf("manifest record %d = %q, want %s of kind %s", i, record, moves[i].Path, moves[i].Kind)
+		}
+		if _, err := os.Lstat(record[0]); !os.IsNotExist(err) {
+			t.Errorf("%s is still in place", record[0])
+		}
+		if content, err := os.ReadFile(record[1]); err != nil || string(content) != files[i+1].content {
+			t.Errorf("quarantined %s = %q, %v", record[1], content, err)
+		}
+	}
+
+	// The path of c/copy.jpg is taken again, it stays in quarantine
+	if err := os.WriteFile(entries[3].Path, []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreFiles(dir); err != nil {
+		t.Fatalf("restoreFiles: %v", err)
+	}
+	for i, file := range files[1:3] {
+		if content, err := os.ReadFile(entries[i+1].Path); err != nil || string(content) != file.content {
+			t.Errorf("restored %s = %q, %v", entries[i+1].Path, content, err)
+		}
+	}
+	remaining, err := readManifest(filepath.Join(dir, manifestFileName))
+	if err != nil {
+		t.Fatalf("readManifest: %v", err)
+	}
+	if len(remaining) != 1 || remaining[0][0] != entries[3].Path {
+		t.Errorf("manifest after restore = %q, want only %s", remaining, entries[3].Path)
+	}
+	if content, err := os.ReadFile(entries[3].Path); err != nil || string(content) != "new" {
+		t.Errorf("restore replaced %s: %q, %v", entries[3].Path, content, err)
+	}
+}
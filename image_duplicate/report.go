@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image/jpeg"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+	"github.com/mattanapol/image_manager/internal/image_helper"
+)
+
+const (
+	reportFile = "report.html"
+	// Largest width and height of a thumbnail in the HTML report
+	thumbnailSize = 240
+)
+
+// reportGroup is a duplicate group as shown in the HTML report, the keeper first.
+type reportGroup struct {
+	ID      int
+	Kind    string // kindExact or kindSimilar, exact and similar groups are numbered separately
+	Members []reportMember
+}
+
+// reportMember is one image of a reportGroup, with its values formatted for display.
+type reportMember struct {
+	Path       string
+	Keeper     bool
+	Similarity int
+	Width      int // Zero when unknown, the dimensions of exact copies are not read
+	Height     int
+	Size       string
+	ModTime    string
+	Thumbnail  template.URL // JPEG data URI, empty when the image could not be read
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Duplicate images</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+section { border-top: 1px solid #ccc; padding: 1em 0; }
+.members { display: flex; flex-wrap: wrap; gap: 1em; }
+figure { margin: 0; width: {{.ThumbnailSize}}px; }
+figure.keeper { outline: 3px solid #2a8; outline-offset: 4px; }
+.thumbnail { width: {{.ThumbnailSize}}px; height: {{.ThumbnailSize}}px; display: flex; align-items: center; justify-content: center; background: #f3f3f3; }
+.thumbnail img { max-width: 100%; max-height: 100%; }
+figcaption { font-size: 0.85em; margin-top: 0.5em; }
+.path { word-break: break-all; color: #555; }
+.badge { color: #2a8; font-weight: bold; }
+.kind { font-size: 0.6em; font-weight: normal; color: #fff; background: #888; border-radius: 0.3em; padding: 0.1em 0.4em; vertical-align: middle; }
+</style>
+</head>
+<body>
+<h1>Duplicate images</h1>
+<p>{{.Exact}} groups of exact copies and {{.Similar}} groups of similar images, created {{.Created}}. The image marked <span class="badge">keep</span> is the suggested one to keep in each group, the others are or look like copies of it.</p>
+{{range $group := .Groups}}<section>
+<h2>Group {{.ID}} <span class="kind">{{.Kind}}</span></h2>
+<div class="members">
+{{range .Members}}<figure{{if .Keeper}} class="keeper"{{end}}>
+<div class="thumbnail">{{if .Thumbnail}}<img src="{{.Thumbnail}}" alt="">{{else}}No preview{{end}}</div>
+<figcaption>
+{{if .Keeper}}<div class="badge">keep</div>{{else if eq $group.Kind "exact"}}<div>exact copy</div>{{else}}<div>{{.Similarity}}% similar</div>{{end}}
+<div>{{if .Width}}{{.Width}} x {{.Height}} pixels, {{end}}{{.Size}}</div>
+<div>Modified {{.ModTime}}</div>
+<div class="path">{{.Path}}</div>
+</figcaption>
+</figure>
+{{end}}</div>
+</section>
+{{end}}</body>
+</html>
+`))
+
+// writeHTMLReport writes a self-contained HTML page showing the exact groups, then the similar
+// groups, as thumbnails with the details of each file, for people who do not read CSV files.
+// Thumbnails are embedded as data URIs so the page can be shared as one file.
+func writeHTMLReport(filename string, exactGroups []ExactGroup, groups []DuplicateGroup) {
+	var reportGroups []reportGroup
+	for _, group := range exactGroups {
+		reportGroup := reportGroup{ID: group.ID, Kind: kindExact}
+		for _, i := range group.keeperFirst() {
+			member := group.Members[i]
+			reportGroup.Members = append(reportGroup.Members, reportMember{
+				Path:    member.Path,
+				Keeper:  i == group.Keeper,
+				Size:    formatBytes(member.Size),
+				ModTime: member.ModTime.Format("2006-01-02 15:04"),
+			})
+		}
+		reportGroups = append(reportGroups, reportGroup)
+	}
+	for _, group := range groups {
+		reportGroup := reportGroup{ID: group.ID, Kind: kindSimilar}
+		for _, i := range group.keeperFirst() {
+			member := group.Members[i]
+			reportGroup.Members = append(reportGroup.Members, reportMember{
+				Path:       member.Path,
+				Keeper:     i == group.Keeper,
+				Similarity: group.similarityToKeeper(member),
+				Width:      member.Width,
+				Height:     member.Height,
+				Size:       formatBytes(member.Size),
+				ModTime:    member.ModTime.Format("2006-01-02 15:04"),
+			})
+		}
+		reportGroups = append(reportGroups, reportGroup)
+	}
+	var members []*reportMember
+	for i := range reportGroups {
+		for j := range reportGroups[i].Members {
+			// Exact copies of videos and other files have no preview
+			if hash_helper.IsImageFile(reportGroups[i].Members[j].Path) {
+				members = append(members, &reportGroups[i].Members[j])
+			}
+		}
+	}
+	fmt.Printf("Creating thumbnails of %d images for the report...\n", len(members))
+	addThumbnails(members)
+
+	file, err := os.Create(filename)
+	if err != nil {
+		fmt.Printf("Error creating HTML report: %v\n", err)
+		return
+	}
+	defer file.Close()
+	err = reportTemplate.Execute(file, map[string]any{
+		"Groups":        reportGroups,
+		"Exact":         len(exactGroups),
+		"Similar":       len(groups),
+		"Created":       time.Now().Format("2006-01-02 15:04"),
+		"ThumbnailSize": thumbnailSize,
+	})
+	if err != nil {
+		fmt.Printf("Error writing HTML report: %v\n", err)
+		return
+	}
+	fmt.Printf("Wrote HTML report to %s\n", filename)
+}
+
+// addThumbnails sets the thumbnail of every member, numberOfThreads at a time.
+func addThumbnails(members []*reportMember) {
+	jobs := make(chan *reportMember)
+	var wg sync.WaitGroup
+	for range numberOfThreads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for member := range jobs {
+				thumbnail, err := thumbnailURI(member.Path)
+				if err != nil {
+					fmt.Printf("Error creating thumbnail of %s: %v\n", member.Path, err)
+					continue
+				}
+				member.Thumbnail = thumbnail
+			}
+		}()
+	}
+	for _, member := range members {
+		jobs <- member
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// thumbnailURI returns a JPEG thumbnail of an image as a data URI.
+func thumbnailURI(path string) (template.URL, error) {
+	img, _, err := image_helper.Decode(path)
+	if err != nil {
+		return "", err
+	}
+	thumbnail := imaging.Fit(img, thumbnailSize, thumbnailSize, imaging.Lanczos)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: 80}); err != nil {
+		return "", err
+	}
+	return template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+// formatBytes formats a file size for people, e.g. 1.5 MB.
+func formatBytes(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d bytes", size)
+	}
+	value, prefix := float64(size)/unit, 0
+	for value >= unit && prefix < 3 {
+		value /= unit
+		prefix++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "kMGT"[prefix])
+}
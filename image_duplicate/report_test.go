@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/corona10/goimagehash"
+)
+
+func TestWriteHTMLReport(t *testing.T) {
+	numberOfThreads = 2
+	root := t.TempDir()
+	paths := make([]string, 4)
+	for i, name := range []string{"exact.png", "exact copy.png", "similar.png", "similar edit.png"} {
+		paths[i] = filepath.Join(root, name)
+		writePNG(t, paths[i], i+1)
+	}
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	hash := goimagehash.NewImageHash(0, goimagehash.PHash)
+	exactGroups := []ExactGroup{{ID: 1, Keeper: 1, Members: []FileEntry{
+		{Path: paths[0], Size: 100, ModTime: modTime},
+		{Path: paths[1], Size: 100, ModTime: modTime},
+	}}}
+	groups := []DuplicateGroup{{ID: 1, Members: []FileInfo{
+		{Path: paths[2], Hash: hash, Width: 32, Height: 32, Size: 100, ModTime: modTime},
+		{Path: paths[3], Hash: hash, Width: 32, Height: 32, Size: 100, ModTime: modTime},
+	}}}
+
+	filename := filepath.Join(root, reportFile)
+	writeHTMLReport(filename, exactGroups, groups)
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := string(content)
+	for _, want := range []string{
+		"1 groups of exact copies and 1 groups of similar images",
+		`Group 1 <span class="kind">exact</span>`,
+		`Group 1 <span class="kind">similar</span>`,
+		"exact copy</div>",
+		"100% similar",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report does not contain %q", want)
+		}
+	}
+	// The exact group comes first with its keeper, then the similar group
+	var positions []int
+	for _, path := range []string{paths[1], paths[0], paths[2], paths[3]} {
+		positions = append(positions, strings.Index(report, path))
+	}
+	for i := 1; i < len(positions); i++ {
+		if positions[i-1] < 0 || positions[i] <= positions[i-1] {
+			t.Errorf("positions of the members = %v, want the keeper of the exact group first and the similar group last", positions)
+			break
+		}
+	}
+	if count := strings.Count(report, "data:image/jpeg;base64,"); count != 4 {
+		t.Errorf("report has %d thumbnails, want 4", count)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resultRow is one member of a group read back from results.csv or exact.csv, see
+// writeGroupsCSV and writeExactGroupsCSV.
+type resultRow struct {
+	GroupID    int
+	Path       string
+	Keeper     bool
+	Kind       string // kindExact or kindSimilar
+	Similarity string
+	Width      int // 0 when the file has no resolution columns
+	Height     int
+	Size       int64
+	ModTime    time.Time
+	Action     string // Action column, empty when the file has none, see runApplyCommand
+}
+
+// resultGroup is a duplicate group read back from results.csv, its rows in file order so the
+// suggested keeper comes first.
+type resultGroup struct {
+	ID      int
+	Members []resultRow
+}
+
+// readResults reads the groups of a results.csv or exact.csv. Columns are found by their header,
+// so columns added or moved by hand do not matter.
+func readResults(filename string) ([]resultGroup, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s is empty", filename)
+	}
+	column := make(map[string]int)
+	for i, header := range records[0] {
+		column[header] = i
+	}
+	for _, header := range []string{"groupId", "filePath", "keeper", "size", "modTime"} {
+		if _, exists := column[header]; !exists {
+			return nil, fmt.Errorf("%s has no %s column", filename, header)
+		}
+	}
+
+	var groups []resultGroup
+	byID := make(map[int]int) // Group ID to index in groups
+	for line, record := range records[1:] {
+		row, err := parseResultRow(record, column)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", filename, line+2, err)
+		}
+		i, exists := byID[row.GroupID]
+		if !exists {
+			i = len(groups)
+			byID[row.GroupID] = i
+			groups = append(groups, resultGroup{ID: row.GroupID})
+		}
+		groups[i].Members = append(groups[i].Members, row)
+	}
+	return groups, nil
+}
+
+// parseResultRow converts a record of results.csv, column maps the headers to their index.
+func parseResultRow(record []string, column map[string]int) (resultRow, error) {
+	var row resultRow
+	var err error
+	if row.GroupID, err = strconv.Atoi(record[column["groupId"]]); err != nil {
+		return row, fmt.Errorf("invalid groupId: %w", err)
+	}
+	row.Path = record[column["filePath"]]
+	if row.Keeper, err = strconv.ParseBool(record[column["keeper"]]); err != nil {
+		return row, fmt.Errorf("invalid keeper: %w", err)
+	}
+	// Results written before the kind column only held similar groups
+	row.Kind = kindSimilar
+	if i, exists := column["kind"]; exists {
+		switch kind := strings.ToLower(strings.TrimSpace(record[i])); kind {
+		case kindExact, kindSimilar:
+			row.Kind = kind
+		default:
+			return row, fmt.Errorf("invalid kind %q", record[i])
+		}
+	}
+	if i, exists := column["similarity"]; exists {
+		row.Similarity = record[i]
+	}
+	if i, exists := column["width"]; exists {
+		if row.Width, err = strconv.Atoi(record[i]); err != nil {
+			return row, fmt.Errorf("invalid width: %w", err)
+		}
+	}
+	if i, exists := column["height"]; exists {
+		if row.Height, err = strconv.Atoi(record[i]); err != nil {
+			return row, fmt.Errorf("invalid height: %w", err)
+		}
+	}
+	if row.Size, err = strconv.ParseInt(record[column["size"]], 10, 64); err != nil {
+		return row, fmt.Errorf("invalid size: %w", err)
+	}
+	if row.ModTime, err = time.Parse(time.RFC3339, record[column["modTime"]]); err != nil {
+		return row, fmt.Errorf("invalid modTime: %w", err)
+	}
+	if i, exists := column["action"]; exists {
+		row.Action = strings.ToLower(strings.TrimSpace(record[i]))
+	}
+	return row, nil
+}
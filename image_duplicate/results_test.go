@@ -0,0 +1,58 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/corona10/goimagehash"
+)
+
+func TestReadResultsKinds(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	similarFile, exactFilename := filepath.Join(dir, outputFile), filepath.Join(dir, exactFile)
+	writeGroupsCSV(similarFile, []DuplicateGroup{{ID: 1, Keeper: 1, Members: []FileInfo{
+		{Path: "a.jpg", Hash: goimagehash.NewImageHash(0b111, goimagehash.PHash), Width: 10, Height: 20, Size: 3, ModTime: modTime},
+		{Path: "b.jpg", Hash: goimagehash.NewImageHash(0b110, goimagehash.PHash), Width: 30, Height: 40, Size: 5, ModTime: modTime},
+	}}})
+	writeExactGroupsCSV(exactFilename, []ExactGroup{{ID: 2, Hash: "abc", Size: 7, Members: []FileEntry{
+		{Path: "c.txt", Size: 7, ModTime: modTime},
+		{Path: "d.txt", Size: 7, ModTime: modTime},
+	}}})
+
+	tests := []struct {
+		file string
+		want []resultRow
+	}{
+		{file: similarFile, want: []resultRow{
+			{GroupID: 1, Path: "b.jpg", Keeper: true, Kind: kindSimilar, Similarity: "100%", Width: 30, Height: 40, Size: 5, ModTime: modTime, Action: actionKeep},
+			{GroupID: 1, Path: "a.jpg", Kind: kindSimilar, Similarity: "99%", Width: 10, Height: 20, Size: 3, ModTime: modTime},
+		}},
+		{file: exactFilename, want: []resultRow{
+			{GroupID: 2, Path: "c.txt", Keeper: true, Kind: kindExact, Size: 7, ModTime: modTime, Action: actionKeep},
+			{GroupID: 2, Path: "d.txt", Kind: kindExact, Size: 7, ModTime: modTime},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(filepath.Base(test.file), func(t *testing.T) {
+			groups, err := readResults(test.file)
+			if err != nil {
+				t.Fatalf("readResults: %v", err)
+			}
+			if len(groups) != 1 || len(groups[0].Members) != len(test.want) {
+				t.Fatalf("readResults = %+v, want one group of %d rows", groups, len(test.want))
+			}
+			for i, row := range groups[0].Members {
+				want := test.want[i]
+				if !row.ModTime.Equal(want.ModTime) {
+					t.Errorf("row %d modTime = %v, want %v", i, row.ModTime, want.ModTime)
+				}
+				row.ModTime, want.ModTime = time.Time{}, time.Time{}
+				if row != want {
+					t.Errorf("row %d = %+v, want %+v", i, row, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mattanapol/image_manager/internal/csv_helper"
+	"github.com/mattanapol/image_manager/internal/file_helper"
+	"github.com/mattanapol/image_manager/internal/image_helper"
+)
+
+// decisionsSuffix replaces the extension of a results file to name its default decisions file,
+// e.g. exact.decisions.csv, so the reviews of results.csv and exact.csv are kept apart.
+const decisionsSuffix = ".decisions.csv"
+
+// Decisions of the review for a file
+const (
+	decisionKeep   = "keep"
+	decisionDelete = "delete"
+	decisionSkip   = "skip"
+)
+
+var decisionsHeaders = []string{"results", "groupId", "filePath", "decision"}
+
+// decisionKey identifies a member of a group. A path can be in a group of results.csv and of
+// exact.csv, each has its own decision.
+type decisionKey struct {
+	GroupID int
+	Path    string
+}
+
+// decisionKey returns the key of the decision on the row.
+func (row resultRow) decisionKey() decisionKey {
+	return decisionKey{GroupID: row.GroupID, Path: row.Path}
+}
+
+const reviewHelp = `Commands:
+  a        accept the suggested keeper (*), delete the others
+  k N...   keep members N..., delete the others
+  s        skip the group, nothing is deleted
+  o [N]    open member N, or every member, with the system viewer
+  b        go back to the previous group
+  q        stop reviewing, the decisions so far are kept
+  ?        show this help`
+
+// reviewer steps through the groups of a results file and records a decision for every member.
+type reviewer struct {
+	in            *bufio.Scanner
+	groups        []resultGroup
+	results       string // Absolute path of the results file, recorded with its decisions
+	decisions     map[decisionKey]string
+	others        [][]string // Rows of the decisions file about other results files, saved as they are
+	decisionsFile string
+}
+
+// runReviewCommand reviews the groups of a results file in the terminal. Decisions are saved
+// after every group, so a review can be stopped and resumed, and are only applied after a final
+// confirmation.
+func runReviewCommand(args []string) {
+	fs := flag.NewFlagSet("review", flag.ExitOnError)
+	results := fs.String("results", outputFile, fmt.Sprintf("Results file written by a scan, %s or %s.", outputFile, exactFile))
+	decisions := fs.String("decisions", "", fmt.Sprintf("File the decisions are saved to and resumed from (default the results file with the extension replaced by %s).", decisionsSuffix))
+	quarantine := fs.String("quarantine", "", "Move the files to delete into this folder, with a manifest to restore them, instead of deleting them.")
+	fs.Parse(args)
+	if *decisions == "" {
+		*decisions = strings.TrimSuffix(*results, filepath.Ext(*results)) + decisionsSuffix
+	}
+
+	groups, err := readResults(*results)
+	if err != nil {
+		fmt.Printf("Error reading results: %v\n", err)
+		os.Exit(1)
+	}
+	absResults, err := filepath.Abs(*results)
+	if err != nil {
+		fmt.Printf("Error reading results: %v\n", err)
+		os.Exit(1)
+	}
+	r := &reviewer{in: bufio.NewScanner(os.Stdin), groups: groups, results: absResults, decisionsFile: *decisions}
+	if r.decisions, r.others, err = readDecisions(*decisions, absResults); err != nil {
+		fmt.Printf("Error reading decisions: %v\n", err)
+		os.Exit(1)
+	}
+
+	r.review()
+	deletions := r.deletions()
+	if len(deletions) == 0 {
+		fmt.Println("Nothing to delete.")
+		return
+	}
+	var bytes int64
+	for _, deletion := range deletions {
+		bytes += deletion.Size
+	}
+	action := "Delete"
+	if *quarantine != "" {
+		action = "Move to " + *quarantine
+	}
+	if !r.confirm(fmt.Sprintf("%s %d files (%d bytes)? [y/N] ", action, len(deletions), bytes)) {
+		fmt.Printf("Nothing changed, the decisions are saved in %s.\n", *decisions)
+		return
+	}
+	if *quarantine != "" {
+		quarantineDeletions(*quarantine, deletions)
+		return
+	}
+	deleteFiles(deletions)
+}
+
+// review asks for a decision on every group, starting at the first one without decisions.
+func (r *reviewer) review() {
+	if len(r.groups) == 0 {
+		fmt.Println("No duplicate groups to review.")
+		return
+	}
+	i := 0
+	for i < len(r.groups) && r.isDecided(r.groups[i]) {
+		i++
+	}
+	if i > 0 {
+		fmt.Printf("Resuming after %d reviewed groups.\n", i)
+	}
+	if i < len(r.groups) {
+		fmt.Println(reviewHelp)
+	}
+
+	for i < len(r.groups) {
+		group := r.groups[i]
+		r.printGroup(i, group)
+		line, ok := r.readLine("> ")
+		if !ok {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "a":
+			r.decide(group, func(n int) bool { return group.Members[n].Keeper })
+			i++
+		case "k":
+			keep, err := memberNumbers(fields[1:], len(group.Members))
+			if err != nil || len(keep) == 0 {
+				fmt.Println("Give the numbers of the members to keep, e.g. k 1 3")
+				continue
+			}
+			r.decide(group, func(n int) bool { return keep[n] })
+			i++
+		case "s":
+			for _, member := range group.Members {
+				r.decisions[member.decisionKey()] = decisionSkip
+			}
+			r.save()
+			i++
+		case "o":
+			open, err := memberNumbers(fields[1:], len(group.Members))
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			for n, member := range group.Members {
+				if len(open) == 0 || open[n] {
+					if err := file_helper.OpenWithViewer(member.Path); err != nil {
+						fmt.Printf("Error opening %s: %v\n", member.Path, err)
+					}
+				}
+			}
+		case "b":
+			i = max(i-1, 0)
+		case "q":
+			return
+		case "?", "h":
+			fmt.Println(reviewHelp)
+		default:
+			fmt.Printf("Unknown command %q, ? shows the commands\n", fields[0])
+		}
+	}
+	fmt.Println("Every group is reviewed.")
+}
+
+// printGroup prints the members of a group side by side with their decision, if any.
+func (r *reviewer) printGroup(i int, group resultGroup) {
+	fmt.Printf("\nGroup %d (%d of %d, %d files):\n", group.ID, i+1, len(r.groups), len(group.Members))
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "  #\tsize\tresolution\tdate taken\tsimilarity\tdecision\tpath")
+	for n, member := range group.Members {
+		marker := " "
+		if member.Keeper {
+			marker = "*"
+		}
+		date := "-"
+		if taken, err := image_helper.DateTaken(member.Path); err == nil {
+			date = taken.Format("2006-01-02 15:04:05")
+		}
+		resolution := "-" // Not in exact.csv
+		if member.Width > 0 {
+			resolution = fmt.Sprintf("%dx%d", member.Width, member.Height)
+		}
+		fmt.Fprintf(table, "%s %d\t%d\t%s\t%s\t%s\t%s\t%s\n", marker, n+1, member.Size, resolution,
+			date, member.Similarity, r.decisions[member.decisionKey()], member.Path)
+	}
+	table.Flush()
+}
+
+// decide keeps the members keep returns true for, by index, deletes the others and saves the
+// decisions.
+func (r *reviewer) decide(group resultGroup, keep func(n int) bool) {
+	for n, member := range group.Members {
+		if keep(n) {
+			r.decisions[member.decisionKey()] = decisionKeep
+		} else {
+			r.decisions[member.decisionKey()] = decisionDelete
+		}
+	}
+	r.save()
+}
+
+// isDecided reports whether every member of the group has a decision.
+func (r *reviewer) isDecided(group resultGroup) bool {
+	for _, member := range group.Members {
+		if r.decisions[member.decisionKey()] == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// save rewrites the decisions file with the decisions of every group, in the order of the results,
+// after the decisions about other results files.
+func (r *reviewer) save() {
+	csv_helper.CreateCSVFileWithHeaders(r.decisionsFile, decisionsHeaders)
+	for _, row := range r.others {
+		csv_helper.AppendResultToCSV(r.decisionsFile, row)
+	}
+	for _, group := range r.groups {
+		for _, member := range group.Members {
+			if decision := r.decisions[member.decisionKey()]; decision != "" {
+				csv_helper.AppendResultToCSV(r.decisionsFile, []string{r.results, strconv.Itoa(group.ID), member.Path, decision})
+			}
+		}
+	}
+}
+
+// deletion is a file the review decided to delete, with its size and modification time in the
+// results.
+type deletion struct {
+	quarantineMove
+	Size    int64
+	ModTime time.Time
+}
+
+// deletions lists the files still to delete. A group without a kept member is left alone, a file
+// is never deleted unless one of its duplicates is kept.
+func (r *reviewer) deletions() []deletion {
+	var deletions []deletion
+	for _, group := range r.groups {
+		keeper := ""
+		for _, member := range group.Members {
+			if r.decisions[member.decisionKey()] == decisionKeep {
+				keeper = member.Path
+				break
+			}
+		}
+		for _, member := range group.Members {
+			if r.decisions[member.decisionKey()] != decisionDelete {
+				continue
+			}
+			if _, err := os.Lstat(member.Path); errors.Is(err, os.ErrNotExist) {
+				continue // Deleted by an earlier run of the review
+			}
+			if keeper == "" {
+				fmt.Printf("Not deleting %s, no member of group %d is kept\n", member.Path, group.ID)
+				continue
+			}
+			deletions = append(deletions, deletion{
+				quarantineMove: quarantineMove{Path: member.Path, Keeper: keeper, Kind: member.Kind, GroupID: group.ID},
+				Size:           member.Size,
+				ModTime:        member.ModTime,
+			})
+		}
+	}
+	return deletions
+}
+
+// readLine prints the prompt and reads a line of input, ok is false at the end of the input.
+func (r *reviewer) readLine(prompt string) (string, bool) {
+	fmt.Print(prompt)
+	if !r.in.Scan() {
+		fmt.Println()
+		return "", false
+	}
+	return strings.TrimSpace(r.in.Text()), true
+}
+
+// confirm asks a yes or no question, anything but yes is no.
+func (r *reviewer) confirm(prompt string) bool {
+	answer, _ := r.readLine(prompt)
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes"
+}
+
+// memberNumbers parses the 1 based member numbers of a command into a set of member indexes.
+func memberNumbers(args []string, count int) (map[int]bool, error) {
+	numbers := make(map[int]bool)
+	for _, arg := range args {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > count {
+			return nil, fmt.Errorf("invalid member number %q, expected 1 to %d", arg, count)
+		}
+		numbers[n-1] = true
+	}
+	return numbers, nil
+}
+
+// deleteFiles deletes the files while their keeper still exists. A file that changed since the
+// scan is left alone, it may no longer be a duplicate.
+func deleteFiles(deletions []deletion) {
+	deleted := 0
+	for _, deletion := range unchangedDeletions(deletions) {
+		if err := os.Remove(deletion.Path); err != nil {
+			fmt.Printf("Error deleting %s: %v\n", deletion.Path, err)
+			continue
+		}
+		deleted++
+	}
+	fmt.Printf("Deleted %d of %d files.\n", deleted, len(deletions))
+}
+
+// quarantineDeletions moves the files to the quarantine folder, with the same checks as deleteFiles.
+func quarantineDeletions(folder string, deletions []deletion) {
+	var moves []quarantineMove
+	for _, deletion := range unchangedDeletions(deletions) {
+		moves = append(moves, deletion.quarantineMove)
+	}
+	quarantineFiles(folder, moves)
+}
+
+// unchangedDeletions returns the deletions whose keeper still exists and whose file has the size and
+// modification time of the scan, and prints why the others are skipped.
+func unchangedDeletions(deletions []deletion) []deletion {
+	var unchanged []deletion
+	for _, deletion := range deletions {
+		if _, err := os.Stat(deletion.Keeper); err != nil {
+			fmt.Printf("Skipping %s, its keeper is missing: %v\n", deletion.Path, err)
+			continue
+		}
+		if err := checkUnchanged(deletion.Path, deletion.Size, deletion.ModTime); err != nil {
+			fmt.Printf("Skipping %s: %v\n", deletion.Path, err)
+			continue
+		}
+		unchanged = append(unchanged, deletion)
+	}
+	return unchanged
+}
+
+// readDecisions reads the decisions about the results file results, and returns the rows about
+// other results files separately. Files written before the results column only hold decisions
+// about the reviewed results. A missing file is an empty review.
+func readDecisions(filename, results string) (map[decisionKey]string, [][]string, error) {
+	decisions := make(map[decisionKey]string)
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return decisions, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return decisions, nil, nil
+	}
+	column := make(map[string]int)
+	for i, header := range records[0] {
+		column[header] = i
+	}
+	for _, header := range []string{"groupId", "filePath", "decision"} {
+		if _, exists := column[header]; !exists {
+			return nil, nil, fmt.Errorf("%s has no %s column", filename, header)
+		}
+	}
+
+	var others [][]string
+	for i, record := range records[1:] {
+		line := i + 2
+		groupID, err := strconv.Atoi(record[column["groupId"]])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s line %d: invalid groupId: %w", filename, line, err)
+		}
+		path, decision := record[column["filePath"]], record[column["decision"]]
+		switch decision {
+		case decisionKeep, decisionDelete, decisionSkip:
+		default:
+			return nil, nil, fmt.Errorf("%s line %d: unknown decision %q", filename, line, decision)
+		}
+		if i, exists := column["results"]; exists && record[i] != results {
+			others = append(others, []string{record[i], strconv.Itoa(groupID), path, decision})
+			continue
+		}
+		decisions[decisionKey{GroupID: groupID, Path: path}] = decision
+	}
+	return decisions, others, nil
+}
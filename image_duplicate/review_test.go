@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeleteFilesSkipsChangedFiles(t *testing.T) {
+	for _, quarantine := range []bool{false, true} {
+		t.Run(map[bool]string{false: "delete", true: "quarantine"}[quarantine], func(t *testing.T) {
+			testRemoveSkipsChangedFiles(t, quarantine)
+		})
+	}
+}
+
+// testRemoveSkipsChangedFiles deletes or quarantines copies of which only the unchanged one with a
+// keeper may go.
+func testRemoveSkipsChangedFiles(t *testing.T, quarantine bool) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	root := t.TempDir()
+	entries := writeFiles(t, root, []testFile{
+		{name: "keeper.jpg", content: "keeper", modTime: modTime},
+		{name: "unchanged.jpg", content: "copy", modTime: modTime},
+		{name: "modified.jpg", content: "copy", modTime: modTime.Add(time.Minute)},
+		{name: "resized.jpg", content: "larger copy", modTime: modTime},
+		{name: "orphan.jpg", content: "copy", modTime: modTime},
+	})
+	keeper := entries[0].Path
+	deletionOf := func(path, keeper string) deletion {
+		return deletion{quarantineMove: quarantineMove{Path: path, Keeper: keeper, Kind: kindSimilar, GroupID: 1},
+			Size: int64(len("copy")), ModTime: modTime}
+	}
+
+	tests := []struct {
+		deletion deletion
+		deleted  bool
+	}{
+		{deletion: deletionOf(entries[1].Path, keeper), deleted: true},
+		{deletion: deletionOf(entries[2].Path, keeper)},
+		{deletion: deletionOf(entries[3].Path, keeper)},
+		{deletion: deletionOf(entries[4].Path, filepath.Join(root, "gone.jpg"))},
+	}
+	var deletions []deletion
+	for _, test := range tests {
+		deletions = append(deletions, test.deletion)
+	}
+	if quarantine {
+		quarantineDeletions(filepath.Join(t.TempDir(), "quarantine"), deletions)
+	} else {
+		deleteFiles(deletions)
+	}
+
+	for _, test := range tests {
+		_, err := os.Lstat(test.deletion.Path)
+		if removed := os.IsNotExist(err); removed != test.deleted {
+			t.Errorf("%s removed = %v, want %v", filepath.Base(test.deletion.Path), removed, test.deleted)
+		}
+	}
+}
+
+func TestDecisionsPerResultsFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "decisions.csv")
+	exact, similar := filepath.Join(dir, exactFile), filepath.Join(dir, outputFile)
+	// Written before the results column, belongs to whichever results are reviewed first
+	legacy := "groupId,filePath,decision\n1,/photos/a.jpg,keep\n"
+	if err := os.WriteFile(filename, []byte(legacy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	decisions, others, err := readDecisions(filename, exact)
+	if err != nil {
+		t.Fatalf("readDecisions: %v", err)
+	}
+	if len(others) != 0 || decisions[decisionKey{GroupID: 1, Path: "/photos/a.jpg"}] != decisionKeep {
+		t.Fatalf("legacy decisions = %v, others %q", decisions, others)
+	}
+	exactReview := &reviewer{results: exact, decisions: decisions, others: others, decisionsFile: filename,
+		groups: []resultGroup{{ID: 1, Members: []resultRow{{GroupID: 1, Path: "/photos/a.jpg"}}}}}
+	exactReview.save()
+
+	// The same path in another results file takes its own decision and keeps the exact one
+	decisions, others, err = readDecisions(filename, similar)
+	if err != nil {
+		t.Fatalf("readDecisions: %v", err)
+	}
+	if len(decisions) != 0 || len(others) != 1 {
+		t.Fatalf("similar decisions = %v, others %q, want only others", decisions, others)
+	}
+	decisions[decisionKey{GroupID: 1, Path: "/photos/a.jpg"}] = decisionDelete
+	similarReview := &reviewer{results: similar, decisions: decisions, others: others, decisionsFile: filename,
+		groups: []resultGroup{{ID: 1, Members: []resultRow{{GroupID: 1, Path: "/photos/a.jpg"}}}}}
+	similarReview.save()
+
+	for results, want := range map[string]string{exact: decisionKeep, similar: decisionDelete} {
+		decisions, others, err := readDecisions(filename, results)
+		if err != nil {
+			t.Fatalf("readDecisions: %v", err)
+		}
+		if got := decisions[decisionKey{GroupID: 1, Path: "/photos/a.jpg"}]; got != want || len(others) != 1 {
+			t.Errorf("decision for %s = %q with %d others, want %q with 1", filepath.Base(results), got, len(others), want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+	"github.com/mattanapol/image_manager/internal/image_helper"
+)
+
+// QueryResult holds the ranked matches of one query image.
+type QueryResult struct {
+	Query   string
+	Matches []Match
+	Crops   []CropMatch  // Only searched with -crops
+	Videos  []VideoMatch // Only searched with -videos
+	Err     error        // Set when the query image could not be hashed
+}
+
+// hasMatch reports whether the query matched an image, an image it is a crop of or a video frame.
+func (r QueryResult) hasMatch() bool {
+	return len(r.Matches) > 0 || len(r.Crops) > 0 || len(r.Videos) > 0
+}
+
+// resolveQueryImages expands the -input argument into the list of query images.
+// A directory is scanned recursively and a non-image file is read as a list of image paths,
+// one per line; both are treated as a batch. A single image is returned as is.
+func resolveQueryImages(input string) ([]string, bool, error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return nil, false, fmt.Errorf("input not found: %s", input)
+	}
+
+	if info.IsDir() {
+		paths, err := findImageFiles(input)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(paths) == 0 {
+			return nil, false, fmt.Errorf("no image files found in input folder: %s", input)
+		}
+		return paths, true, nil
+	}
+
+	if image_helper.HasImageExtension(input) || image_helper.IsImage(input) {
+		return []string{input}, false, nil
+	}
+
+	paths, err := readQueryListFile(input)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(paths) == 0 {
+		return nil, false, fmt.Errorf("input list file contains no image paths: %s", input)
+	}
+	return paths, true, nil
+}
+
+// readQueryListFile reads image paths from a text file, one per line.
+// Blank lines and lines starting with '#' are ignored, relative paths are resolved against the list file.
+func readQueryListFile(listFile string) ([]string, error) {
+	file, err := os.Open(listFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input list file %s: %w", listFile, err)
+	}
+	defer file.Close()
+
+	baseDir := filepath.Dir(listFile)
+	var paths []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(baseDir, line)
+		}
+		paths = append(paths, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read input list file %s: %w", listFile, err)
+	}
+	return paths, nil
+}
+
+// runBatchQueries hashes every query image once and ranks it against the already built candidate index.
+func runBatchQueries(queryPaths []string, folder *folderIndex, concurrency int, opts searchOptions, limit int) []QueryResult {
+	index, cropIndex, imageHashes := folder.Index, folder.CropIndex, folder.Cache.Entries
+	fmt.Fprintf(statusOut, "\nCalculating hashes for %d query images...\n", len(queryPaths))
+	// Reuse the candidate entries for queries that live in the indexed folder, the rest are hashed
+	// into a separate map so they never end up in the persisted cache.
+	queryHashes := make(hash_helper.Entries, len(queryPaths))
+	for _, path := range queryPaths {
+		if entry, exists := imageHashes[path]; exists {
+			queryHashes[path] = entry
+		}
+	}
+	// Queries are only compared as a whole, their own regions are never needed
+	querySpec := opts.Spec
+	querySpec.Regions = false
+	queryHashes = hashImages(queryPaths, queryHashes, concurrency, querySpec)
+
+	results := make([]QueryResult, 0, len(queryPaths))
+	for _, queryPath := range queryPaths {
+		result := QueryResult{Query: queryPath}
+		entry, exists := queryHashes[queryPath]
+		absQueryPath, err := filepath.Abs(queryPath)
+		switch {
+		case !exists:
+			result.Err = fmt.Errorf("could not process image")
+		case err != nil:
+			result.Err = err
+		case opts.Transforms:
+			// Rotated and mirrored versions are not cached, hash them from the decoded query
+			queries, transformErr := calculateTransformHashes(queryPath, opts.Spec, true)
+			if transformErr != nil {
+				result.Err = transformErr
+				break
+			}
+			result.Matches = rankTransformedMatches(queries, absQueryPath, index, imageHashes, opts)
+			if folder.FrameIndex != nil {
+				result.Videos = findVideoFrames(queries, folder.FrameIndex, imageHashes, opts)
+			}
+		default:
+			signature := signatureFor(entry, opts.Spec)
+			result.Matches = rankMatches(signature, absQueryPath, index, imageHashes, opts)
+			if folder.FrameIndex != nil {
+				query := transformedHashes{Hashes: signature.Hashes, Color: signature.Color}
+				result.Videos = findVideoFrames([]transformedHashes{query}, folder.FrameIndex, imageHashes, opts)
+			}
+		}
+		if result.Err == nil && opts.Spec.Regions {
+			result.Crops = findCrops(hashesFor(entry, opts.Spec)[0], absQueryPath, cropIndex, imageHashes, opts, result.Matches)
+		}
+		if limit > 0 {
+			result.Matches = result.Matches[:min(limit, len(result.Matches))]
+			result.Crops = result.Crops[:min(limit, len(result.Crops))]
+			result.Videos = result.Videos[:min(limit, len(result.Videos))]
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// printBatchResults prints one row per query with its best matches or "no match".
+func printBatchResults(results []QueryResult, opts searchOptions) {
+	matched := 0
+	for _, result := range results {
+		if result.hasMatch() {
+			matched++
+		}
+	}
+
+	fmt.Printf("\n--- Batch Results: %d of %d queries matched ---\n", matched, len(results))
+	fmt.Printf("Thresholds: distance <= %d, similarity >= %.2f%% (%s)\n\n", opts.DistanceThreshold, opts.Threshold, opts.Spec)
+
+	// The colour columns are only shown with -color
+	colorHeader, noColor := "", ""
+	if opts.Spec.Color {
+		colorHeader, noColor = "\tColour\tScore", "\t-\t-"
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(writer, "Query\tDistance\tSimilarity%s\tTransform\tMatches\n", colorHeader)
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			fmt.Fprintf(writer, "%s\t-\t-%s\t-\terror: %v\n", result.Query, noColor, result.Err)
+		case len(result.Matches) == 0 && len(result.Crops) > 0:
+			crop := result.Crops[0]
+			fmt.Fprintf(writer, "%s\t%d\t%.2f%%%s\tcrop\tlikely a crop of %s (overlap %.0f%%)\n",
+				result.Query, crop.Distance, crop.Similarity, noColor, crop.Path, crop.Overlap*100)
+		case len(result.Matches) == 0 && len(result.Videos) > 0:
+			video := result.Videos[0]
+			color := noColor
+			if opts.Spec.Color {
+				color = fmt.Sprintf("\t%.2f%%\t%.2f%%", video.ColorSimilarity, video.Score)
+			}
+			fmt.Fprintf(writer, "%s\t%d\t%.2f%%%s\tvideo\tframe of %s at %s\n",
+				result.Query, video.Distance, video.Similarity, color, video.Path, formatTimestamp(video.Timestamp))
+		case len(result.Matches) == 0:
+			fmt.Fprintf(writer, "%s\t-\t-%s\t-\tno match\n", result.Query, noColor)
+		default:
+			best := result.Matches[0]
+			paths := make([]string, len(result.Matches))
+			for i, match := range result.Matches {
+				paths[i] = match.Path
+			}
+			transform := best.Transform
+			if transform == "" {
+				transform = identityTransform
+			}
+			color := ""
+			if opts.Spec.Color {
+				color = fmt.Sprintf("\t%.2f%%\t%.2f%%", best.ColorSimilarity, best.Score)
+			}
+			fmt.Fprintf(writer, "%s\t%d\t%.2f%%%s\t%s\t%s\n", result.Query, best.Distance, best.Similarity, color, transform, strings.Join(paths, "; "))
+		}
+	}
+	writer.Flush()
+}
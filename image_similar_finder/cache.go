@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/corona10/goimagehash"
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+)
+
+// addSignature stores the hashes, dimensions, regions and colour histogram of a signature in
+// the entry.
+func addSignature(e *hash_helper.Entry, signature *imageSignature) {
+	e.AddHashes(signature.Hashes)
+	e.Width, e.Height = signature.Width, signature.Height
+	if signature.Regions != nil {
+		e.Regions = signature.Regions
+		e.RegionLayout = cropRegionLayout
+		// Regions use the first algorithm, see hashRegions
+		e.RegionKind, e.RegionBits = signature.Hashes[0].GetKind(), signature.Hashes[0].Bits()
+	}
+	if signature.Color != nil {
+		e.Color = signature.Color
+		e.ColorLayout = colorHistogramLayout
+	}
+}
+
+// hasSpec reports whether the entry holds every hash required by spec.
+func hasSpec(e *hash_helper.Entry, spec hashSpec) bool {
+	if spec.Regions && !hasRegions(e, spec) {
+		return false
+	}
+	if spec.Color && !hasColor(e) {
+		return false
+	}
+	return hashesFor(e, spec) != nil
+}
+
+// signatureFor returns the hashes and colour histogram required by spec as a query signature,
+// or nil when the entry lacks one of the hashes.
+func signatureFor(e *hash_helper.Entry, spec hashSpec) *imageSignature {
+	hashes := hashesFor(e, spec)
+	if hashes == nil {
+		return nil
+	}
+	signature := &imageSignature{Hashes: hashes, Width: e.Width, Height: e.Height}
+	if spec.Color && hasColor(e) {
+		signature.Color = e.Color
+	}
+	return signature
+}
+
+// hashesFor returns the hashes required by spec in the order of spec.Algorithms,
+// or nil when the entry lacks one of them.
+func hashesFor(e *hash_helper.Entry, spec hashSpec) []*goimagehash.ExtImageHash {
+	hashes := make([]*goimagehash.ExtImageHash, 0, len(spec.Algorithms))
+	for _, algorithm := range spec.Algorithms {
+		hash := e.Hash(algorithm.Kind, spec.Bits())
+		if hash == nil {
+			return nil
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
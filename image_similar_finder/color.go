@@ -0,0 +1,138 @@
+package main
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+)
+
+const (
+	// Images are downscaled to fit this size before building the histogram, a coarse histogram
+	// does not need more pixels
+	colorWorkingSize = 128
+	// Histogram layout: hue x saturation x value bins for coloured pixels, plus value bins for
+	// grey pixels whose hue is meaningless
+	colorHueBins        = 8
+	colorSaturationBins = 3
+	colorValueBins      = 3
+	colorGrayBins       = 4
+	// Pixels below this saturation or value are counted as grey
+	colorGraySaturation = 0.15
+	colorGrayValue      = 0.1
+	// Bump when the histogram layout changes so cached histograms are recomputed
+	colorHistogramLayout = 1
+	// Default for -color-threshold
+	defaultColorThreshold = 60.0
+	// Weight of the colour similarity in the combined score, the rest is the hash similarity
+	colorScoreWeight = 0.5
+)
+
+// colorHistogramSize is the number of bins of a colour histogram.
+const colorHistogramSize = colorHueBins*colorSaturationBins*colorValueBins + colorGrayBins
+
+// colorHistogram computes the coarse HSV histogram of an image, normalised so the bins sum to 1.
+// Fully transparent pixels are ignored.
+func colorHistogram(img image.Image) []float32 {
+	small := imaging.Fit(img, colorWorkingSize, colorWorkingSize, imaging.Box)
+	counts := make([]float64, colorHistogramSize)
+	total := 0.0
+	for i := 0; i+3 < len(small.Pix); i += 4 {
+		alpha := float64(small.Pix[i+3]) / 255
+		if alpha == 0 {
+			continue
+		}
+		addColor(counts, small.Pix[i], small.Pix[i+1], small.Pix[i+2], alpha)
+		total += alpha
+	}
+	histogram := make([]float32, colorHistogramSize)
+	if total == 0 {
+		return histogram // Matches no other image
+	}
+	for i, count := range counts {
+		histogram[i] = float32(count / total)
+	}
+	return histogram
+}
+
+// addColor adds the weight of an RGB colour to the histogram. The weight is split linearly
+// between the two nearest bins on every axis, so a slight colour shift (JPEG compression, a
+// small white balance change) moves weight to the neighbouring bin instead of flipping it.
+func addColor(counts []float64, r, g, b uint8, weight float64) {
+	red, green, blue := float64(r)/255, float64(g)/255, float64(b)/255
+	value := math.Max(red, math.Max(green, blue))
+	chroma := value - math.Min(red, math.Min(green, blue))
+	saturation := 0.0
+	if value > 0 {
+		saturation = chroma / value
+	}
+	if saturation < colorGraySaturation || value < colorGrayValue {
+		for _, v := range splitBins(value, colorGrayBins, false) {
+			counts[colorHueBins*colorSaturationBins*colorValueBins+v.bin] += weight * v.weight
+		}
+		return
+	}
+
+	var hue float64 // In sixths of the colour wheel, [0, 6)
+	switch value {
+	case red:
+		hue = math.Mod((green-blue)/chroma+6, 6)
+	case green:
+		hue = (blue-red)/chroma + 2
+	default:
+		hue = (red-green)/chroma + 4
+	}
+	for _, h := range splitBins(hue/6, colorHueBins, true) {
+		for _, s := range splitBins(saturation, colorSaturationBins, false) {
+			for _, v := range splitBins(value, colorValueBins, false) {
+				counts[(h.bin*colorSaturationBins+s.bin)*colorValueBins+v.bin] += weight * h.weight * s.weight * v.weight
+			}
+		}
+	}
+}
+
+// binWeight is the share of a value assigned to a bin.
+type binWeight struct {
+	bin    int
+	weight float64
+}
+
+// splitBins splits a value in [0, 1] between the two bins whose centres surround it. On a
+// circular axis (hue) the last bin neighbours the first one, otherwise values beyond the outer
+// centres go to the outer bin entirely.
+func splitBins(value float64, bins int, circular bool) [2]binWeight {
+	position := value*float64(bins) - 0.5 // Position relative to the bin centres
+	lower := int(math.Floor(position))
+	fraction := position - float64(lower)
+	upper := lower + 1
+	if circular {
+		lower, upper = (lower+bins)%bins, upper%bins
+	} else {
+		lower, upper = max(lower, 0), min(upper, bins-1)
+	}
+	return [2]binWeight{{bin: lower, weight: 1 - fraction}, {bin: upper, weight: fraction}}
+}
+
+// colorSimilarityPercent compares two histograms by their intersection: 100% when they are
+// identical, 0% when they have no colour in common.
+func colorSimilarityPercent(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	intersection := 0.0
+	for i := range a {
+		intersection += float64(min(a[i], b[i]))
+	}
+	return math.Min(intersection, 1) * 100
+}
+
+// combinedScore merges the hash similarity and the colour similarity of a match into one score.
+func combinedScore(similarity, colorSimilarity float64) float64 {
+	return (1-colorScoreWeight)*similarity + colorScoreWeight*colorSimilarity
+}
+
+// hasColor reports whether the entry holds a colour histogram of the current layout.
+func hasColor(e *hash_helper.Entry) bool {
+	return e.ColorLayout == colorHistogramLayout && len(e.Color) == colorHistogramSize
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"time"
+
+	"github.com/corona10/goimagehash"
+	"github.com/disintegration/imaging"
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+)
+
+const (
+	// Images are downscaled to fit this size before cutting regions, regional hashes only look
+	// at a few pixels anyway and this keeps -crops affordable on large photos
+	cropWorkingSize = 512
+	// Regions smaller than this many pixels on a side are not hashed
+	cropMinRegionSize = 16
+	// Bump when cropRegions changes so cached regions are recomputed
+	cropRegionLayout = 1
+)
+
+// cropRegionScales are the side lengths, as a fraction of the image, of the overlapping grid
+// regions. Each scale is sampled on a 3x3 grid: both edges and the centre on each axis.
+var cropRegionScales = []float64{0.5, 0.625, 0.75, 0.875}
+
+// CropMatch describes a candidate image that the query is likely a crop of.
+type CropMatch struct {
+	Path       string
+	Distance   int
+	Similarity float64
+	Overlap    float64                // Estimated share of the candidate covered by the query
+	Region     hash_helper.RegionHash // Region of the candidate that matched
+}
+
+// hasRegions reports whether the regional hashes of the entry were computed for the first
+// algorithm of spec. Images too small for any region hold none but are up to date all the same.
+func hasRegions(e *hash_helper.Entry, spec hashSpec) bool {
+	kind, bits := e.RegionKind, e.RegionBits
+	if bits == 0 && len(e.Regions) > 0 {
+		// Entries cached before the region kind was stored
+		kind, bits = e.Regions[0].Hash.Kind, e.Regions[0].Hash.Bits
+	}
+	return e.RegionLayout == cropRegionLayout && kind == spec.Algorithms[0].Kind && bits == spec.Bits()
+}
+
+// cropRegions returns the regions to hash for an image of the given size: the overlapping
+// grid regions of every scale plus, for non-square images, square crops along the long side
+// (the usual social media crop).
+func cropRegions(bounds image.Rectangle) []image.Rectangle {
+	width, height := bounds.Dx(), bounds.Dy()
+	var regions []image.Rectangle
+	for _, scale := range cropRegionScales {
+		regionWidth, regionHeight := int(float64(width)*scale), int(float64(height)*scale)
+		for _, y := range []int{0, (height - regionHeight) / 2, height - regionHeight} {
+			for _, x := range []int{0, (width - regionWidth) / 2, width - regionWidth} {
+				regions = append(regions, image.Rect(x, y, x+regionWidth, y+regionHeight))
+			}
+		}
+	}
+
+	side := min(width, height)
+	if width != height {
+		for _, offset := range []int{0, (max(width, height) - side) / 2, max(width, height) - side} {
+			if width > height {
+				regions = append(regions, image.Rect(offset, 0, offset+side, side))
+			} else {
+				regions = append(regions, image.Rect(0, offset, side, offset+side))
+			}
+		}
+	}
+
+	valid := regions[:0]
+	for _, region := range regions {
+		if region.Dx() >= cropMinRegionSize && region.Dy() >= cropMinRegionSize {
+			valid = append(valid, region.Add(bounds.Min))
+		}
+	}
+	return valid
+}
+
+// hashRegions computes the hash of the first algorithm of spec for every crop region of the image.
+func hashRegions(img image.Image, spec hashSpec) []hash_helper.RegionHash {
+	small := imaging.Fit(img, cropWorkingSize, cropWorkingSize, imaging.Box)
+	bounds := small.Bounds()
+	algorithm := spec.Algorithms[0]
+
+	// Not nil even without regions, so the entry records that they were computed
+	regions := []hash_helper.RegionHash{}
+	for _, rect := range cropRegions(bounds) {
+		hash, err := algorithm.Compute(small.SubImage(rect), spec.Width, spec.Width)
+		if err != nil {
+			continue
+		}
+		regions = append(regions, hash_helper.RegionHash{
+			X:    float32(rect.Min.X-bounds.Min.X) / float32(bounds.Dx()),
+			Y:    float32(rect.Min.Y-bounds.Min.Y) / float32(bounds.Dy()),
+			W:    float32(rect.Dx()) / float32(bounds.Dx()),
+			H:    float32(rect.Dy()) / float32(bounds.Dy()),
+			Hash: hash_helper.StoredHash{Hash: hash.GetHash(), Kind: hash.GetKind(), Bits: hash.Bits()},
+		})
+	}
+	return regions
+}
+
+// cropIndexSpecKey identifies the hash the crop index is built on.
+func cropIndexSpecKey(spec hashSpec) string {
+	return "crop:" + indexSpecKey(spec)
+}
+
+// cropFingerprint is the indexFingerprint equivalent for the regional hashes of the candidates.
+func cropFingerprint(candidatePaths []string, imageHashes hash_helper.Entries, spec hashSpec) uint64 {
+	var fingerprint uint64
+	for _, path := range candidatePaths {
+		entry := imageHashes[path]
+		if entry == nil || !hasRegions(entry, spec) {
+			continue
+		}
+		for i, region := range entry.Regions {
+			fingerprint ^= hash_helper.NodeFingerprint(path, int32(i), region.Hash.Hash)
+		}
+	}
+	return fingerprint
+}
+
+// ensureCropIndex returns the persisted crop index when it still matches the candidates, or
+// builds a new one over the regional hashes and stores it in the cache.
+func ensureCropIndex(cache *hash_helper.Cache, candidatePaths []string, spec hashSpec) *hash_helper.BKTree {
+	fingerprint := cropFingerprint(candidatePaths, cache.Entries, spec)
+	if cache.CropIndex != nil && cache.CropIndex.Spec == cropIndexSpecKey(spec) && cache.CropIndex.Fingerprint == fingerprint {
+		fmt.Fprintf(statusOut, "Using cached crop index (%d regions).\n", len(cache.CropIndex.Nodes))
+		return cache.CropIndex
+	}
+
+	fmt.Fprintln(statusOut, "Building crop index...")
+	start := time.Now()
+	paths := make([]string, 0, len(candidatePaths))
+	for _, path := range candidatePaths {
+		if entry := cache.Entries[path]; entry != nil && hasRegions(entry, spec) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	tree := &hash_helper.BKTree{Spec: cropIndexSpecKey(spec), Fingerprint: fingerprint}
+	for _, path := range paths {
+		for i, region := range cache.Entries[path].Regions {
+			tree.Insert(hash_helper.Node{Path: path, Region: int32(i), Hash: region.Hash.Hash})
+		}
+	}
+	cache.CropIndex = tree
+	fmt.Fprintf(statusOut, "Indexed %d regions of %d images in %v.\n", len(tree.Nodes), len(paths), time.Since(start))
+	return tree
+}
+
+// findCrops looks up the whole-image hash of the query among the regional hashes and returns the
+// candidates the query is likely a crop of, best region per candidate, sorted by distance.
+// Candidates listed in exclude (the direct matches) are skipped.
+func findCrops(queryHash *goimagehash.ExtImageHash, absInputImagePath string, cropIndex *hash_helper.BKTree,
+	imageHashes hash_helper.Entries, opts searchOptions, exclude []Match) []CropMatch {
+	excluded := make(map[string]bool, len(exclude))
+	for _, match := range exclude {
+		excluded[match.Path] = true
+	}
+
+	results, _ := cropIndex.Query(queryHash.GetHash(), opts.DistanceThreshold)
+	best := make(map[string]CropMatch)
+	for _, result := range results {
+		entry := imageHashes[result.Path]
+		if excluded[result.Path] || entry == nil || int(result.Region) >= len(entry.Regions) {
+			continue
+		}
+		similarity := calculateSimilarityPercent(result.Distance, opts.Spec.Bits())
+		if similarity < opts.Threshold {
+			continue
+		}
+		region := entry.Regions[result.Region]
+		match := CropMatch{Path: result.Path, Distance: result.Distance, Similarity: similarity, Overlap: region.Overlap(), Region: region}
+		current, exists := best[result.Path]
+		if !exists || match.Distance < current.Distance || (match.Distance == current.Distance && match.Overlap > current.Overlap) {
+			best[result.Path] = match
+		}
+	}
+
+	crops := make([]CropMatch, 0, len(best))
+	for path, match := range best {
+		if isSameFile(path, absInputImagePath) {
+			continue
+		}
+		crops = append(crops, match)
+	}
+	sort.Slice(crops, func(i, j int) bool {
+		if crops[i].Distance != crops[j].Distance {
+			return crops[i].Distance < crops[j].Distance
+		}
+		return crops[i].Path < crops[j].Path
+	})
+	return crops
+}
+
+// printCropMatches prints up to limit crop matches (all of them if limit <= 0).
+func printCropMatches(crops []CropMatch, limit int) {
+	if len(crops) == 0 {
+		fmt.Println("\nNo image found that the input is likely a crop of.")
+		return
+	}
+	if limit > 0 && len(crops) > limit {
+		crops = crops[:limit]
+	}
+
+	fmt.Printf("\n--- Input Is Likely a Crop Of %d Image(s) ---\n", len(crops))
+	fmt.Printf("%8s  %10s  %7s  %s\n", "Distance", "Similarity", "Overlap", "Path")
+	for _, crop := range crops {
+		fmt.Printf("%8d  %9.2f%%  %6.0f%%  %s (region x=%.2f y=%.2f w=%.2f h=%.2f)\n",
+			crop.Distance, crop.Similarity, crop.Overlap*100, crop.Path, crop.Region.X, crop.Region.Y, crop.Region.W, crop.Region.H)
+	}
+}
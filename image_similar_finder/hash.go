@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"strings"
+
+	"github.com/corona10/goimagehash"
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+	"github.com/mattanapol/image_manager/internal/image_helper"
+)
+
+const (
+	// Default hash width, the hash has width*width bits (8 -> 64 bits like the standard pHash)
+	defaultHashWidth = 8
+	// Largest hash width (256 bits), the hash and its comparisons grow with width*width
+	maxHashWidth = 16
+	// Default algorithm selection for -algo
+	defaultHashAlgorithms = "perception"
+	// Separator used to combine several algorithms in -algo, e.g. "perception+difference"
+	hashAlgorithmSeparator = "+"
+)
+
+// hashSpec describes which hashes are computed for every image. When several algorithms are
+// selected a candidate has to be within the threshold for each of them to count as a match.
+type hashSpec struct {
+	Algorithms []hash_helper.Algorithm
+	Width      int
+	Regions    bool // Also compute regional hashes of the first algorithm for crop detection
+	Color      bool // Also compute a colour histogram to compare colours, see colorHistogram
+}
+
+// imageSignature holds everything computed from one decoded image.
+type imageSignature struct {
+	Hashes  []*goimagehash.ExtImageHash // One hash per algorithm, in the order of spec.Algorithms
+	Width   int                         // Image size in pixels
+	Height  int
+	Regions []hash_helper.RegionHash // Regional hashes, only when spec.Regions is set
+	Color   []float32                // Colour histogram, only when spec.Color is set
+}
+
+// parseHashSpec parses the -algo and -hash-width flags.
+func parseHashSpec(algorithms string, width int) (hashSpec, error) {
+	if width <= 0 || width > maxHashWidth {
+		return hashSpec{}, fmt.Errorf("hash width must be between 1 and %d, got %d", maxHashWidth, width)
+	}
+	// pHash needs width*height to be a power of 2, keep the same rule for every algorithm so all
+	// hashes of an image share one size and one distance threshold
+	bits := width * width
+	if bits&(bits-1) != 0 {
+		return hashSpec{}, fmt.Errorf("hash width * width must be a power of 2, got %d", bits)
+	}
+
+	spec := hashSpec{Width: width}
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(algorithms, hashAlgorithmSeparator) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		algorithm, ok := hash_helper.Algorithms[name]
+		if !ok {
+			return hashSpec{}, fmt.Errorf("unknown hash algorithm %q (supported: average, difference, perception)", name)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		spec.Algorithms = append(spec.Algorithms, algorithm)
+	}
+	return spec, nil
+}
+
+// Bits returns the size in bits of each hash produced by the spec.
+func (s hashSpec) Bits() int {
+	return s.Width * s.Width
+}
+
+// String returns the spec in the -algo notation with its size, e.g. "perception+difference/64".
+func (s hashSpec) String() string {
+	names := make([]string, len(s.Algorithms))
+	for i, algorithm := range s.Algorithms {
+		names[i] = algorithm.Name
+	}
+	return fmt.Sprintf("%s/%d", strings.Join(names, hashAlgorithmSeparator), s.Bits())
+}
+
+// calculateHash calculates the perceptual hashes selected by spec for a given image file.
+func calculateHash(imagePath string, spec hashSpec) (*imageSignature, error) {
+	img, err := decodeImage(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return signImage(img, spec, imagePath), nil
+}
+
+// signImage computes the signature selected by spec for a decoded image, or nil when hashing fails.
+func signImage(img image.Image, spec hashSpec, name string) *imageSignature {
+	hashes := hashImage(img, spec, name)
+	if hashes == nil {
+		return nil
+	}
+	bounds := img.Bounds()
+	signature := &imageSignature{Hashes: hashes, Width: bounds.Dx(), Height: bounds.Dy()}
+	if spec.Regions {
+		signature.Regions = hashRegions(img, spec)
+	}
+	if spec.Color {
+		signature.Color = colorHistogram(img)
+	}
+	return signature
+}
+
+// decodeImage decodes an image file of any format supported by image_helper, the format is
+// detected from the content so a wrong extension does not matter.
+func decodeImage(imagePath string) (image.Image, error) {
+	img, _, err := image_helper.Decode(imagePath)
+	return img, err
+}
+
+// hashImage computes the hashes selected by spec for a decoded image, name is only used in warnings.
+func hashImage(img image.Image, spec hashSpec, name string) []*goimagehash.ExtImageHash {
+	hashes := make([]*goimagehash.ExtImageHash, 0, len(spec.Algorithms))
+	for _, algorithm := range spec.Algorithms {
+		hash, err := algorithm.Compute(img, spec.Width, spec.Width)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Error calculating %s hash for %s: %v\n", algorithm.Name, name, err)
+			return nil // Return nil hash if calculation fails
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+// hashDistance compares the query hashes with a cache entry for every algorithm of the spec and
+// returns the worst (largest) distance. ok is false when the entry lacks one of the hashes.
+func hashDistance(queryHashes []*goimagehash.ExtImageHash, entry *hash_helper.Entry) (int, bool) {
+	worst := 0
+	for _, queryHash := range queryHashes {
+		candidateHash := entry.Hash(queryHash.GetKind(), queryHash.Bits())
+		if candidateHash == nil {
+			return 0, false
+		}
+		distance, err := queryHash.Distance(candidateHash)
+		if err != nil {
+			return 0, false
+		}
+		worst = max(worst, distance)
+	}
+	return worst, true
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseHashSpecWidth(t *testing.T) {
+	tests := []struct {
+		width   int
+		wantErr string
+	}{
+		{width: 1},
+		{width: 8},
+		{width: 16},
+		{width: 0, wantErr: "between 1 and 16"},
+		{width: -8, wantErr: "between 1 and 16"},
+		{width: 32, wantErr: "between 1 and 16"},
+		{width: 6, wantErr: "power of 2"},
+	}
+	for _, test := range tests {
+		_, err := parseHashSpec("perception", test.width)
+		if test.wantErr == "" && err != nil {
+			t.Errorf("parseHashSpec with width %d: %v", test.width, err)
+		}
+		if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+			t.Errorf("parseHashSpec with width %d = %v, want an error containing %q", test.width, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+)
+
+// indexSpecKey identifies the hash the index is built on: the first algorithm of the spec.
+// Additional algorithms of a combined spec are checked on the index results.
+func indexSpecKey(spec hashSpec) string {
+	return fmt.Sprintf("%s/%d", spec.Algorithms[0].Name, spec.Bits())
+}
+
+// indexedHash returns the raw primary hash of an entry for the index, or nil when it is missing.
+func indexedHash(entry *hash_helper.Entry, spec hashSpec) []uint64 {
+	if entry == nil {
+		return nil
+	}
+	hash := entry.Hash(spec.Algorithms[0].Kind, spec.Bits())
+	if hash == nil {
+		return nil
+	}
+	return hash.GetHash()
+}
+
+// indexFingerprint combines the paths and primary hashes of all candidates into a single value,
+// so a persisted index can be validated without comparing it node by node.
+func indexFingerprint(candidatePaths []string, imageHashes hash_helper.Entries, spec hashSpec) uint64 {
+	var fingerprint uint64
+	for _, path := range candidatePaths {
+		hash := indexedHash(imageHashes[path], spec)
+		if hash == nil {
+			continue
+		}
+		// XOR keeps the fingerprint independent of the walk order
+		fingerprint ^= hash_helper.NodeFingerprint(path, 0, hash)
+	}
+	return fingerprint
+}
+
+// isValidIndex reports whether a (possibly persisted) index still describes the candidates.
+func isValidIndex(t *hash_helper.BKTree, spec hashSpec, fingerprint uint64) bool {
+	return t.IsValidFor(indexSpecKey(spec), fingerprint)
+}
+
+// buildBKTree indexes the primary hash of every candidate that has one.
+func buildBKTree(candidatePaths []string, imageHashes hash_helper.Entries, spec hashSpec, fingerprint uint64) *hash_helper.BKTree {
+	paths := make([]string, 0, len(candidatePaths))
+	for _, path := range candidatePaths {
+		if indexedHash(imageHashes[path], spec) != nil {
+			paths = append(paths, path)
+		}
+	}
+	// Insert in a fixed order so the same folder always produces the same tree
+	sort.Strings(paths)
+
+	tree := &hash_helper.BKTree{
+		Spec:        indexSpecKey(spec),
+		Fingerprint: fingerprint,
+		Nodes:       make([]hash_helper.Node, 0, len(paths)),
+	}
+	for _, path := range paths {
+		tree.Insert(hash_helper.Node{Path: path, Hash: indexedHash(imageHashes[path], spec)})
+	}
+	return tree
+}
+
+// ensureIndex returns the persisted index when it still matches the candidates, or builds a new
+// one and stores it in the cache so it is saved with the hashes.
+func ensureIndex(cache *hash_helper.Cache, candidatePaths []string, spec hashSpec) *hash_helper.BKTree {
+	fingerprint := indexFingerprint(candidatePaths, cache.Entries, spec)
+	if isValidIndex(cache.Index, spec, fingerprint) {
+		fmt.Fprintf(statusOut, "Using cached similarity index (%d images).\n", len(cache.Index.Nodes))
+		return cache.Index
+	}
+
+	fmt.Fprintln(statusOut, "Building similarity index...")
+	start := time.Now()
+	cache.Index = buildBKTree(candidatePaths, cache.Entries, spec, fingerprint)
+	fmt.Fprintf(statusOut, "Indexed %d images in %v.\n", len(cache.Index.Nodes), time.Since(start))
+	return cache.Index
+}
+
+// rankMatches looks up the query hashes in the index and returns all matches within the
+// thresholds, best match first (see sortMatches).
+func rankMatches(query *imageSignature, absInputImagePath string, index *hash_helper.BKTree,
+	imageHashes hash_helper.Entries, opts searchOptions) []Match {
+	candidates, _ := index.Query(query.Hashes[0].GetHash(), opts.DistanceThreshold)
+
+	var matches []Match
+	for _, candidate := range candidates {
+		// Re-check with every algorithm of the spec, the index only covers the first one
+		match, ok := scoreCandidate(query, candidate.Path, imageHashes[candidate.Path], opts)
+		if !ok {
+			continue
+		}
+		if isSameFile(candidate.Path, absInputImagePath) {
+			continue
+		}
+		matches = append(matches, match)
+	}
+	sortMatches(matches)
+	return matches
+}
+
+// rankMatchesLinear is the reference implementation of rankMatches that compares the input with
+// every candidate one by one. It is kept to benchmark the index against.
+func rankMatchesLinear(query *imageSignature, absInputImagePath string, candidatePaths []string,
+	imageHashes hash_helper.Entries, opts searchOptions) []Match {
+	var matches []Match
+	for _, candidatePath := range candidatePaths {
+		match, ok := scoreCandidate(query, candidatePath, imageHashes[candidatePath], opts)
+		if !ok {
+			continue
+		}
+		if isSameFile(candidatePath, absInputImagePath) {
+			continue
+		}
+		matches = append(matches, match)
+	}
+	sortMatches(matches)
+	return matches
+}
+
+// isSameFile reports whether a candidate is the input image itself. Absolute paths are only
+// resolved for matches so the search never pays for filepath.Abs on every candidate.
+func isSameFile(candidatePath string, absInputImagePath string) bool {
+	absCandidatePath, err := filepath.Abs(candidatePath)
+	return err == nil && absCandidatePath == absInputImagePath
+}
+
+// benchmarkIndex runs the same queries through the linear scan and the index and prints the timings.
+// Queries are the hashes of randomly picked candidates (fixed seed), so every run is comparable.
+func benchmarkIndex(candidatePaths []string, imageHashes hash_helper.Entries, index *hash_helper.BKTree, opts searchOptions, queries int) {
+	var querySignatures []*imageSignature
+	random := rand.New(rand.NewSource(1))
+	for attempts := 0; len(querySignatures) < queries && attempts < queries*10 && len(candidatePaths) > 0; attempts++ {
+		entry := imageHashes[candidatePaths[random.Intn(len(candidatePaths))]]
+		if entry == nil {
+			continue
+		}
+		if signature := signatureFor(entry, opts.Spec); signature != nil {
+			querySignatures = append(querySignatures, signature)
+		}
+	}
+	if len(querySignatures) == 0 {
+		fmt.Println("No hashed candidates to benchmark with.")
+		return
+	}
+
+	fmt.Printf("\nBenchmarking %d queries over %d indexed images (distance <= %d)...\n",
+		len(querySignatures), len(index.Nodes), opts.DistanceThreshold)
+
+	start := time.Now()
+	linearMatches := 0
+	for _, signature := range querySignatures {
+		linearMatches += len(rankMatchesLinear(signature, "", candidatePaths, imageHashes, opts))
+	}
+	linear := time.Since(start)
+
+	start = time.Now()
+	indexMatches := 0
+	for _, signature := range querySignatures {
+		indexMatches += len(rankMatches(signature, "", index, imageHashes, opts))
+	}
+	indexed := time.Since(start)
+
+	visited := 0
+	for _, signature := range querySignatures {
+		_, nodes := index.Query(signature.Hashes[0].GetHash(), opts.DistanceThreshold)
+		visited += nodes
+	}
+
+	perQuery := func(total time.Duration) time.Duration { return total / time.Duration(len(querySignatures)) }
+	fmt.Printf("Linear scan: %v per query (%d matches)\n", perQuery(linear), linearMatches)
+	fmt.Printf("BK-tree:     %v per query (%d matches, %.1f%% of nodes visited)\n", perQuery(indexed), indexMatches,
+		100*float64(visited)/float64(len(querySignatures)*max(len(index.Nodes), 1)))
+	if indexed > 0 {
+		fmt.Printf("Speedup:     %.1fx\n", float64(linear)/float64(indexed))
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/corona10/goimagehash"
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+)
+
+// testEntry returns an entry holding one pHash of 64 bits.
+func testEntry(hash uint64) *hash_helper.Entry {
+	entry := &hash_helper.Entry{}
+	entry.AddHashes([]*goimagehash.ExtImageHash{goimagehash.NewExtImageHash([]uint64{hash}, goimagehash.PHash, 64)})
+	return entry
+}
+
+func TestEnsureIndexRebuildsOnFingerprintMismatch(t *testing.T) {
+	statusOut = io.Discard
+	spec, err := parseHashSpec("perception", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		change  func(cache *hash_helper.Cache, paths []string) []string
+		rebuild bool
+	}{
+		{name: "unchanged", change: func(cache *hash_helper.Cache, paths []string) []string { return paths }},
+		{name: "changed hash", rebuild: true, change: func(cache *hash_helper.Cache, paths []string) []string {
+			cache.Entries["b.jpg"] = testEntry(0xff00)
+			return paths
+		}},
+		{name: "added image", rebuild: true, change: func(cache *hash_helper.Cache, paths []string) []string {
+			cache.Entries["c.jpg"] = testEntry(0x0ff0)
+			return append(paths, "c.jpg")
+		}},
+		{name: "removed image", rebuild: true, change: func(cache *hash_helper.Cache, paths []string) []string {
+			return paths[:1]
+		}},
+		{name: "other algorithm", rebuild: true, change: func(cache *hash_helper.Cache, paths []string) []string {
+			cache.Index.Spec = "average/64"
+			return paths
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := hash_helper.NewCache()
+			cache.Entries["a.jpg"] = testEntry(0x00ff)
+			cache.Entries["b.jpg"] = testEntry(0x00fe)
+			paths := []string{"a.jpg", "b.jpg"}
+			built := ensureIndex(cache, paths, spec)
+
+			paths = test.change(cache, paths)
+			index := ensureIndex(cache, paths, spec)
+			if rebuilt := index != built; rebuilt != test.rebuild {
+				t.Fatalf("rebuilt = %v, want %v", rebuilt, test.rebuild)
+			}
+			if cache.Index != index {
+				t.Error("the index is not stored in the cache")
+			}
+			if len(index.Nodes) != len(paths) {
+				t.Errorf("index has %d nodes, want %d", len(index.Nodes), len(paths))
+			}
+			results, _ := index.Query(indexedHash(cache.Entries["a.jpg"], spec), 0)
+			if len(results) != 1 || results[0].Path != "a.jpg" {
+				t.Errorf("Query of a.jpg = %v", results)
+			}
+		})
+	}
+}
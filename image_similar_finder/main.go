@@ -1,119 +1,76 @@
 package main
 
 import (
-	"encoding/gob"
 	"flag"
 	"fmt"
-	"image"
-	_ "image/gif"  // Register GIF decoder
-	_ "image/jpeg" // Register JPEG decoder
-	_ "image/png"  // Register PNG decoder
-	"io/fs"
-	"log"
 	"math"
 	"os"
 	"path/filepath"
 	"runtime"
-	"strings"
-	"sync"
-
-	// External dependencies - run 'go get <path>' for these
-	"github.com/corona10/goimagehash"   // Perceptual hashing
-	"github.com/schollz/progressbar/v3" // Progress bar
-	// Note: You might need to add more image format decoders if needed, e.g.,
-	// _ "golang.org/x/image/bmp"
-	// _ "golang.org/x/image/tiff"
-)
-
-// Supported image extensions (lowercase)
-var imageExtensions = map[string]struct{}{
-	".png":  {},
-	".jpg":  {},
-	".jpeg": {},
-	".bmp":  {}, // Requires golang.org/x/image/bmp
-	".gif":  {},
-	".tiff": {}, // Requires golang.org/x/image/tiff
-}
+	"sort"
 
-const (
-	// Default hash size used by goimagehash pHash (usually 64 bits)
-	defaultHashSize = 64
-	// Default name for the cache file
-	defaultCacheFileName = ".image_hashes.gob"
+	"github.com/mattanapol/image_manager/internal/hash_helper"
 )
 
-// ImageHashCache stores the mapping from file path to its perceptual hash.
-// Using ExtImageHash as it's generally serializable.
-type ImageHashCache map[string]*goimagehash.ExtImageHash
-
-// HashJob represents a path to be processed by a worker.
-type HashJob struct {
-	Path string
+// Match describes a candidate image whose hash is within the distance threshold of the input.
+// When several algorithms are combined, Distance and Similarity are those of the worst algorithm.
+type Match struct {
+	Path       string
+	Distance   int
+	Similarity float64
+	Transform  string // Rotation or flip of the query that produced the match, see queryTransforms
+	// Colour similarity percentage, only computed with -color
+	ColorSimilarity float64
+	// Combined hash and colour similarity used to rank matches, the hash Similarity without -color
+	Score float64
 }
 
-// HashResult holds the result from a worker.
-type HashResult struct {
-	Path string
-	Hash *goimagehash.ExtImageHash
-	Err  error
+// searchOptions holds the matching parameters shared by every search mode.
+type searchOptions struct {
+	Spec              hashSpec
+	Threshold         float64 // Minimum similarity percentage
+	DistanceThreshold int     // Maximum Hamming distance, applied to every algorithm of Spec
+	Transforms        bool    // Also match the rotated and mirrored versions of the query
+	ColorThreshold    float64 // Minimum colour similarity percentage, only used when Spec.Color is set
 }
 
-// calculateHash calculates the perceptual hash for a given image file.
-func calculateHash(imagePath string) (*goimagehash.ExtImageHash, error) {
-	// Basic extension check first
-	if !isImageExtension(imagePath) {
-		return nil, nil // Not an error, just skip non-image files silently like python version
-	}
-
-	file, err := os.Open(imagePath)
-	if err != nil {
-		// fmt.Fprintf(os.Stderr, "Warning: Could not open file %s: %v\n", imagePath, err)
-		return nil, nil // Suppress warning like python version
-	}
-	defer file.Close()
-
-	img, _, err := image.Decode(file)
-	if err != nil {
-		// fmt.Fprintf(os.Stderr, "Warning: Could not decode image %s: %v\n", imagePath, err)
-		return nil, nil // Suppress warning like python version
-	}
-
-	// Using pHash (PerceptionHash) as in the Python example
-	hash, err := goimagehash.ExtPerceptionHash(img, 8, 8) // Standard pHash parameters
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: Error calculating hash for %s: %v\n", imagePath, err)
-		return nil, nil // Return nil hash if calculation fails
+// scoreCandidate compares the query signature with a candidate entry and reports whether it is a
+// match. With -color the candidate has to pass both the hash and the colour threshold.
+func scoreCandidate(query *imageSignature, candidatePath string, entry *hash_helper.Entry, opts searchOptions) (Match, bool) {
+	if entry == nil {
+		return Match{}, false
+	}
+	distance, ok := hashDistance(query.Hashes, entry)
+	if !ok || distance > opts.DistanceThreshold {
+		return Match{}, false
+	}
+	similarityPercent := calculateSimilarityPercent(distance, opts.Spec.Bits())
+	// Ensure floating point inaccuracies don't show slightly below threshold
+	if similarityPercent < opts.Threshold {
+		return Match{}, false
+	}
+	match := Match{Path: candidatePath, Distance: distance, Similarity: similarityPercent, Score: similarityPercent}
+	if opts.Spec.Color {
+		if query.Color == nil || !hasColor(entry) {
+			return Match{}, false
+		}
+		match.ColorSimilarity = colorSimilarityPercent(query.Color, entry.Color)
+		if match.ColorSimilarity < opts.ColorThreshold {
+			return Match{}, false
+		}
+		match.Score = combinedScore(match.Similarity, match.ColorSimilarity)
 	}
-
-	return hash, nil
-}
-
-// isImageExtension checks if a file path has a supported image extension.
-func isImageExtension(filePath string) bool {
-	ext := strings.ToLower(filepath.Ext(filePath))
-	_, supported := imageExtensions[ext]
-	return supported
+	return match, true
 }
 
 // findImageFiles recursively finds all potential image files in the given folder.
 func findImageFiles(folderPath string) ([]string, error) {
-	fmt.Printf("Scanning folder: %s\n", folderPath)
-	var imageFiles []string
-	err := filepath.WalkDir(folderPath, func(path string, info fs.DirEntry, err error) error {
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: Error accessing path %q: %v\n", path, err)
-			return nil
-		}
-		if !info.IsDir() && isImageExtension(path) {
-			imageFiles = append(imageFiles, path)
-		}
-		return nil
-	})
-
+	fmt.Fprintf(statusOut, "Scanning folder: %s\n", folderPath)
+	imageFiles, err := hash_helper.FindImages(folderPath, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error walking the path %q: %v", folderPath, err)
+		return nil, err
 	}
-	fmt.Printf("Found %d potential image files to check.\n", len(imageFiles))
+	fmt.Fprintf(statusOut, "Found %d potential image files to check.\n", len(imageFiles))
 	return imageFiles, nil
 }
 
@@ -141,310 +98,320 @@ func calculateSimilarityPercent(distance int, hashSize int) float64 {
 	return similarity
 }
 
-// loadHashesFromCache loads image hashes from a cache file using gob encoding.
-func loadHashesFromCache(cacheFile string) (ImageHashCache, error) {
-	hashes := make(ImageHashCache)
-	if _, err := os.Stat(cacheFile); os.IsNotExist(err) {
-		fmt.Printf("Cache file %s not found, starting fresh.\n", cacheFile)
-		return hashes, nil // No cache file is not an error
-	}
-
-	fmt.Printf("Loading image hashes from cache: %s\n", cacheFile)
-	file, err := os.Open(cacheFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open cache file %s: %w", cacheFile, err)
-	}
-	defer file.Close()
-
-	decoder := gob.NewDecoder(file)
-	if err := decoder.Decode(&hashes); err != nil {
-		// If cache is corrupted or format changed, might be better to start fresh
-		fmt.Fprintf(os.Stderr, "Warning: Could not decode cache file %s (maybe corrupted or format changed?), starting fresh: %v\n", cacheFile, err)
-		return make(ImageHashCache), nil // Return empty map instead of error
-	}
+// hashImages makes sure imageHashes holds a fresh entry with the hashes of spec for every path,
+// hashing new or changed files with concurrency workers.
+func hashImages(imagePaths []string, imageHashes hash_helper.Entries, concurrency int, spec hashSpec) hash_helper.Entries {
+	has := func(entry *hash_helper.Entry) bool { return hasSpec(entry, spec) }
+	hash_helper.Update(imageHashes, imagePaths, concurrency, has, func(path string) (func(*hash_helper.Entry), error) {
+		signature, err := calculateHash(path, spec)
+		if signature == nil {
+			return nil, err
+		}
+		return func(entry *hash_helper.Entry) { addSignature(entry, signature) }, err
+	})
+	return imageHashes
+}
 
-	return hashes, nil
+// sortMatches sorts by score (the hash similarity unless -color is set), then by distance and
+// path so that equal scores are reported in a stable order.
+func sortMatches(matches []Match) {
+	sort.Slice(matches, func(i, j int) bool { return betterMatch(matches[i], matches[j]) })
 }
 
-// saveHashesToCache saves image hashes to a cache file using gob encoding.
-func saveHashesToCache(cacheFile string, imageHashes ImageHashCache) error {
-	file, err := os.Create(cacheFile)
-	if err != nil {
-		return fmt.Errorf("failed to create cache file %s: %w", cacheFile, err)
+// betterMatch reports whether a ranks before b in sortMatches.
+func betterMatch(a, b Match) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
 	}
-	defer file.Close()
-
-	encoder := gob.NewEncoder(file)
-	if err := encoder.Encode(imageHashes); err != nil {
-		// TODO: this error because goimagehash.ExtImageHash has no export value.
-		return fmt.Errorf("failed to encode hashes to cache file %s: %w", cacheFile, err)
+	if a.Distance != b.Distance {
+		return a.Distance < b.Distance
 	}
-	fmt.Printf("Saved %d image hashes to cache: %s\n", len(imageHashes), cacheFile)
-	return nil
+	return a.Path < b.Path
 }
 
-// calculateHashesParallel calculates image hashes in parallel for the given image paths using a semaphore.
-func calculateHashesParallel(imagePaths []string, existingHashes ImageHashCache, numWorkers int) ImageHashCache {
-	// Default to the number of logical CPUs if numWorkers is not specified or invalid.
-	// This is the standard Go approach for CPU-bound tasks.
-	// For I/O-bound tasks, sometimes numWorkers > runtime.NumCPU() can be beneficial,
-	// but requires profiling to determine the optimal number.
-	if numWorkers <= 0 {
-		numWorkers = runtime.NumCPU()
-	}
-	// GOMAXPROCS defaults to runtime.NumCPU() since Go 1.5, ensuring Go can potentially
-	// utilize all cores for its goroutines. You usually don't need to set this manually.
-	fmt.Printf("Using %d worker goroutines for hash calculation (GOMAXPROCS=%d).\n", numWorkers, runtime.GOMAXPROCS(0))
-
-	// Determine paths that need processing (filter out cached entries)
-	pathsToProcess := make([]string, 0, len(imagePaths)) // Pre-allocate slice capacity
-	for _, path := range imagePaths {
-		if _, exists := existingHashes[path]; !exists {
-			pathsToProcess = append(pathsToProcess, path)
-		}
+// printRankedMatches prints up to limit matches (all of them if limit <= 0).
+func printRankedMatches(inputImage string, matches []Match, limit int, opts searchOptions) {
+	if len(matches) == 0 {
+		fmt.Printf("\nNo similar image found matching the threshold (>= %.2f%%)\n", opts.Threshold)
+		return
+	}
+	shown := matches
+	if limit > 0 && len(shown) > limit {
+		shown = shown[:limit]
 	}
 
-	totalToProcess := len(pathsToProcess)
-	if totalToProcess == 0 {
-		fmt.Println("All image hashes found in cache. No new calculations needed.")
-		return existingHashes // Return original map as no changes were made
-	}
-
-	fmt.Printf("Calculating hashes for %d new images...\n", totalToProcess)
-
-	// Use buffered channels matching the number of jobs/results to avoid blocking
-	// if channel processing is slower than job generation/completion, though
-	// totalToProcess buffering might use significant memory for large inputs.
-	// A smaller buffer (e.g., numWorkers * 2) is often a good compromise.
-	jobs := make(chan HashJob, totalToProcess)
-	results := make(chan HashResult, totalToProcess)
-	var wg sync.WaitGroup
-
-	// Start workers
-	// These workers will concurrently pull paths from the 'jobs' channel,
-	// process them using calculateHash, and send results to the 'results' channel.
-	for w := 1; w <= numWorkers; w++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-			// Process jobs until the 'jobs' channel is closed
-			for job := range jobs {
-				// *** The performance bottleneck is often HERE (in calculateHash) ***
-				// It might be CPU-bound (good for this parallel model)
-				// OR I/O-bound (disk read, network), which means the CPU
-				// associated with this goroutine might idle while waiting.
-				// Profiling (pprof) is essential to understand behavior.
-				hash, err := calculateHash(job.Path)
-				results <- HashResult{Path: job.Path, Hash: hash, Err: err}
-			}
-		}(w)
-	}
-
-	// Send jobs to the workers via the 'jobs' channel
-	for _, path := range pathsToProcess {
-		jobs <- HashJob{Path: path}
-	}
-	// Close the 'jobs' channel to signal workers that no more jobs are coming.
-	// Workers currently ranging over 'jobs' will finish their current job (if any)
-	// and then exit their loop upon detecting the closed channel.
-	close(jobs)
-
-	// Start a dedicated goroutine to wait for all workers to finish *before*
-	// closing the results channel. This prevents a panic if we try to read
-	// from 'results' after closing it but before all workers are done sending.
-	go func() {
-		wg.Wait()      // Wait for all goroutines launched with wg.Add(1) to call wg.Done()
-		close(results) // Close the results channel *after* all workers have finished
-	}()
-
-	// Collect results
-	// Using a progress bar for user feedback
-	bar := progressbar.Default(int64(totalToProcess), "Hashing Images") // Use real progress bar
-	newHashes := make(ImageHashCache)                                   // Store newly computed hashes here
-
-	// Read from the 'results' channel until it's closed (by the goroutine above).
-	// This loop automatically terminates when 'results' is closed and empty.
-	for res := range results {
-		if res.Err != nil {
-			// Log error appropriately instead of just printing to stderr maybe?
-			fmt.Printf("Warning: Error processing %s: %v\n", res.Path, res.Err)
-			// Depending on requirements, you might want to collect errors
-		} else if res.Hash != nil {
-			// Store successfully computed hashes
-			newHashes[res.Path] = res.Hash
-		}
-		// Increment progress bar for each result received (success or error)
-		_ = bar.Add(1) // Ignore error for simplicity here
+	fmt.Printf("\n--- %d Match(es) Found (showing %d) ---\n", len(matches), len(shown))
+	fmt.Printf("Input Image: '%s'\n", inputImage)
+	fmt.Printf("Thresholds:  distance <= %d, similarity >= %.2f%% (%s)\n", opts.DistanceThreshold, opts.Threshold, opts.Spec)
+	if opts.Spec.Color {
+		fmt.Printf("             colour similarity >= %.2f%%\n", opts.ColorThreshold)
 	}
 
-	// It's safe to merge now because the results loop is finished, meaning all
-	// workers have completed and sent their results.
-	if len(newHashes) > 0 {
-		fmt.Printf("\nMerging %d newly calculated hashes into the cache.\n", len(newHashes))
-		for path, hash := range newHashes {
-			existingHashes[path] = hash // Add new hashes to the map passed in
+	fmt.Printf("\n%4s  %8s  %10s", "Rank", "Distance", "Similarity")
+	if opts.Spec.Color {
+		fmt.Printf("  %8s  %7s", "Colour", "Score")
+	}
+	if opts.Transforms {
+		fmt.Printf("  %-10s", "Transform")
+	}
+	fmt.Println("  Path")
+	for i, match := range shown {
+		fmt.Printf("%4d  %8d  %9.2f%%", i+1, match.Distance, match.Similarity)
+		if opts.Spec.Color {
+			fmt.Printf("  %7.2f%%  %6.2f%%", match.ColorSimilarity, match.Score)
 		}
-	} else {
-		fmt.Println("\nNo new hashes were successfully calculated.")
+		if opts.Transforms {
+			fmt.Printf("  %-10s", match.Transform)
+		}
+		fmt.Printf("  %s\n", match.Path)
 	}
-
-	return existingHashes // Return the modified map
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "cache":
+			runCacheCommand(os.Args[2:])
+			return
+		case "serve":
+			runServeCommand(os.Args[2:])
+			return
+		}
+	}
+
 	// --- Argument Parsing ---
-	inputImage := flag.String("input", "", "Path to the input image file (required)")
+	inputImage := flag.String("input", "", "Path to the input image file, a folder of images or a text file listing one image per line (required)")
 	searchFolder := flag.String("folder", "", "Path to the folder to search (required)")
 	threshold := flag.Float64("threshold", 90.0, "Similarity threshold percentage (0-100). Default: 90.0")
 	concurrency := flag.Int("concurrency", runtime.NumCPU()-1, "Number of concurrent processes. Defaults to CPU count.")
-	cacheFile := flag.String("cache", "", fmt.Sprintf("Path to the cache file. Defaults to '%s' in the search folder.", defaultCacheFileName))
+	cacheFile := flag.String("cache", "", fmt.Sprintf("Path to the cache file. Defaults to '%s' in the search folder.", hash_helper.DefaultCacheFileName))
+	algo := flag.String("algo", defaultHashAlgorithms, "Hash algorithm: average, difference or perception. Combine with '+' (e.g. perception+difference) to require a match for every algorithm.")
+	hashWidth := flag.Int("hash-width", defaultHashWidth, "Hash width, each hash has width*width bits (8 = 64 bits, 16 = 256 bits, the largest).")
+	top := flag.Int("top", 0, "Score every candidate and print the N closest matches sorted by score.")
+	all := flag.Bool("all", false, "Score every candidate and print all matches sorted by score.")
+	rotations := flag.Bool("rotations", false, "Also match the 8 rotations and flips of the input image and report which one matched.")
+	crops := flag.Bool("crops", false, "Index hashes of overlapping regions of every image and report images the input is likely a crop of.")
+	color := flag.Bool("color", false, "Also compare colour histograms, a match has to pass both the similarity and the colour threshold and is ranked by their combined score.")
+	colorThreshold := flag.Float64("color-threshold", defaultColorThreshold, "Colour similarity threshold percentage (0-100), used with -color.")
+	videos := flag.Bool("videos", false, "Also sample the keyframes of the videos in the folder with ffmpeg and report the video and timestamp of the closest frame.")
+	frameInterval := flag.Duration("frame-interval", defaultFrameInterval, "Minimum time between two sampled keyframes of a video, used with -videos.")
+	benchmark := flag.Int("benchmark-index", 0, "Benchmark N queries through the similarity index against a linear scan, then exit.")
+	prune := flag.Bool("prune", false, "Drop cache entries of files that no longer exist. Run 'image_similar_finder cache stats -folder <folder>' to see how many there are.")
+	format := flag.String("format", formatText, "Output format: text, json or csv. With json and csv only the results are written to stdout, status messages go to stderr.\n"+
+		"Exit code: 0 when a query has a match, even if other queries failed (they are listed on stderr), 1 when no query has a match, 2 on error when no query has a match.")
 
 	flag.Parse()
 
 	// --- Input Validation ---
 	if *inputImage == "" {
-		log.Fatal("Error: Input image path (-input) is required.")
+		fatalf("Error: Input image path (-input) is required.")
 	}
 	if *searchFolder == "" {
-		log.Fatal("Error: Search folder path (-folder) is required.")
+		fatalf("Error: Search folder path (-folder) is required.")
 	}
 
-	if info, err := os.Stat(*inputImage); err != nil || info.IsDir() {
-		log.Fatalf("Error: Input image not found or is a directory: %s", *inputImage)
+	if *top < 0 {
+		fatalf("Error: -top must be a positive number.")
+	}
+	outputFormat, err := parseOutputFormat(*format)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	if outputFormat != formatText {
+		statusOut = os.Stderr
+		hash_helper.StatusOut = os.Stderr
+	}
+
+	queryPaths, isBatch, err := resolveQueryImages(*inputImage)
+	if err != nil {
+		fatalf("Error: %v", err)
 	}
 	if info, err := os.Stat(*searchFolder); err != nil || !info.IsDir() {
-		log.Fatalf("Error: Search folder not found or is not a directory: %s", *searchFolder)
+		fatalf("Error: Search folder not found or is not a directory: %s", *searchFolder)
 	}
 
-	distanceThreshold, err := convertPercentToDistance(*threshold, defaultHashSize)
+	spec, err := parseHashSpec(*algo, *hashWidth)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	spec.Regions = *crops
+	spec.Color = *color
+	if *colorThreshold < 0 || *colorThreshold > 100 {
+		fatalf("Error: -color-threshold must be between 0 and 100.")
+	}
+	if *frameInterval < 0 {
+		fatalf("Error: -frame-interval must not be negative.")
+	}
+	distanceThreshold, err := convertPercentToDistance(*threshold, spec.Bits())
 	if err != nil {
-		log.Fatalf("Error: %v", err)
+		fatalf("Error: %v", err)
 	}
-	fmt.Printf("Similarity threshold: %.2f%% translates to max Hamming distance: %d (for hash size %d)\n",
-		*threshold, distanceThreshold, defaultHashSize)
+	fmt.Fprintf(statusOut, "Similarity threshold: %.2f%% translates to max Hamming distance: %d (for hash %s)\n",
+		*threshold, distanceThreshold, spec)
+	opts := searchOptions{Spec: spec, Threshold: *threshold, DistanceThreshold: distanceThreshold, Transforms: *rotations, ColorThreshold: *colorThreshold}
 
 	// --- Determine Cache File Path ---
 	cacheFilePath := *cacheFile
 	if cacheFilePath == "" {
-		cacheFilePath = filepath.Join(*searchFolder, defaultCacheFileName)
+		cacheFilePath = filepath.Join(*searchFolder, hash_helper.DefaultCacheFileName)
 	}
 
-	// --- Load Hashes from Cache ---
-	imageHashes, err := loadHashesFromCache(cacheFilePath)
+	// --- Hash the Search Folder and Build or Reuse the Similarity Index ---
+	scan := scanOptions{Folder: *searchFolder, CacheFile: cacheFilePath, Spec: spec, Concurrency: *concurrency, Prune: *prune,
+		Videos: *videos, FrameInterval: *frameInterval}
+	folder, err := openFolderIndex(scan)
 	if err != nil {
-		// loadHashesFromCache already prints warnings, maybe just log fatal if it's critical
-		log.Printf("Warning: Proceeding without cache due to error: %v", err)
-		imageHashes = make(ImageHashCache) // Ensure it's initialized
+		fatalf("Error: %v", err)
 	}
+	candidatePaths, imageHashes := folder.CandidatePaths, folder.Cache.Entries
+	index, cropIndex := folder.Index, folder.CropIndex
 
-	// --- Find Candidate Images ---
-	candidatePaths, err := findImageFiles(*searchFolder)
-	if err != nil {
-		log.Fatalf("Error finding image files: %v", err)
-	}
-	if len(candidatePaths) == 0 {
-		fmt.Println("No potential image files found in the search folder.")
-		os.Exit(0)
-	}
-
-	// --- Calculate Hashes for Candidate Images (with Concurrency) ---
-	if *concurrency <= 0 {
-		*concurrency = 1 // Ensure at least one worker
-	}
-	if *concurrency == 1 {
-		fmt.Println("Calculating image hashes sequentially...")
-		bar := progressbar.Default(int64(len(candidatePaths)), "Hashing Images")
-		for _, path := range candidatePaths {
-			if _, exists := imageHashes[path]; !exists {
-				hash, _ := calculateHash(path) // Ignore error like python version
-				if hash != nil {
-					imageHashes[path] = hash
-				}
-			}
-			bar.Add(1)
-		}
-	} else {
-		imageHashes = calculateHashesParallel(candidatePaths, imageHashes, *concurrency)
+	if *benchmark > 0 {
+		benchmarkIndex(candidatePaths, imageHashes, index, opts, *benchmark)
+		return
 	}
 
-	// --- Save Hashes to Cache ---
-	if err := saveHashesToCache(cacheFilePath, imageHashes); err != nil {
-		log.Printf("Warning: Could not save cache file %s: %v", cacheFilePath, err)
+	// Number of matches reported per query when they are not all listed
+	limit := *top
+	if *all {
+		limit = 0
+	} else if limit == 0 {
+		limit = 1 // Best match only
+	}
+
+	// --- Batch Queries Against the Candidate Index ---
+	if isBatch {
+		results := runBatchQueries(queryPaths, folder, *concurrency, opts, limit)
+		if outputFormat == formatText {
+			printBatchResults(results, opts)
+		} else if err := writeResults(os.Stdout, outputFormat, results, opts); err != nil {
+			fatalf("Error writing results: %v", err)
+		}
+		reportFailedQueries(results)
+		os.Exit(exitCodeFor(results))
 	}
 
 	// --- Calculate Hash for Input Image ---
-	fmt.Printf("\nCalculating hash for input image: %s\n", *inputImage)
-	inputHash, err := calculateHash(*inputImage)
+	fmt.Fprintf(statusOut, "\nCalculating hash for input image: %s\n", *inputImage)
+	inputQueries, err := calculateTransformHashes(*inputImage, spec, opts.Transforms)
 	if err != nil {
-		// calculateHash itself might return nil error for decode/open issues
-		log.Printf("Warning trying to calculate input hash: %v", err) // Log potential underlying error
+		fatalf("Error: Could not process input image: %v", err)
+	}
+	for _, inputHash := range inputQueries[0].Hashes {
+		fmt.Fprintf(statusOut, "Input image hash: %s\n", inputHash.ToString()) // Use ToString for readable hex
 	}
-	if inputHash == nil {
-		log.Fatalf("Error: Could not process input image: %s", *inputImage)
+	if opts.Transforms {
+		fmt.Fprintf(statusOut, "Also searching %d rotated and mirrored versions of the input image.\n", len(inputQueries)-1)
 	}
-	fmt.Printf("Input image hash: %s\n", inputHash.ToString()) // Use ToString for readable hex
 
 	// Resolve to absolute paths to prevent matching the same file via different relative paths
 	absInputImagePath, err := filepath.Abs(*inputImage)
 	if err != nil {
-		log.Fatalf("Error getting absolute path for input image: %v", err)
+		fatalf("Error getting absolute path for input image: %v", err)
 	}
 
-	// --- Search for First Similar Image ---
-	fmt.Printf("\nSearching for first similar image (Threshold >= %.2f%%)...\n", *threshold)
-	foundMatch := false
-	processedCount := 0
-
-	bar := progressbar.Default(int64(len(candidatePaths)), "Scanning Candidates")
-
-	for _, candidatePath := range candidatePaths {
-		bar.Add(1)
-		processedCount++
+	// --- Search the Index ---
+	fmt.Fprintf(statusOut, "\nSearching the similarity index (Threshold >= %.2f%%)...\n", *threshold)
+	matches := rankTransformedMatches(inputQueries, absInputImagePath, index, imageHashes, opts)
+	var cropMatches []CropMatch
+	if spec.Regions {
+		cropMatches = findCrops(inputQueries[0].Hashes[0], absInputImagePath, cropIndex, imageHashes, opts, matches)
+	}
+	var videoMatches []VideoMatch
+	if *videos {
+		videoMatches = findVideoFrames(inputQueries, folder.FrameIndex, imageHashes, opts)
+	}
+	result := QueryResult{Query: *inputImage, Matches: matches, Crops: cropMatches, Videos: videoMatches}
+	exitCode := exitNoMatch
+	if result.hasMatch() {
+		exitCode = exitMatch
+	}
 
-		// Avoid comparing the input file to itself using absolute paths
-		absCandidatePath, err := filepath.Abs(candidatePath)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: Could not get absolute path for %s: %v\n", candidatePath, err)
-			continue // Skip if we can't resolve path
+	// --- Machine-Readable Results ---
+	if outputFormat != formatText {
+		if limit > 0 {
+			result.Matches = result.Matches[:min(limit, len(result.Matches))]
+			result.Crops = result.Crops[:min(limit, len(result.Crops))]
+			result.Videos = result.Videos[:min(limit, len(result.Videos))]
 		}
-		if absCandidatePath == absInputImagePath {
-			continue
+		if err := writeResults(os.Stdout, outputFormat, []QueryResult{result}, opts); err != nil {
+			fatalf("Error writing results: %v", err)
 		}
+		os.Exit(exitCode)
+	}
 
-		// Get hash for the candidate image from the calculated/cached hashes
-		candidateHash, exists := imageHashes[candidatePath]
-		if !exists || candidateHash == nil {
-			continue // Skip if hash wasn't calculated or is nil
+	// --- Ranked Results ---
+	if *all || *top > 0 {
+		printRankedMatches(*inputImage, matches, limit, opts)
+		if spec.Regions {
+			printCropMatches(cropMatches, limit)
 		}
-
-		// Compare hashes using Hamming distance
-		distance, err := inputHash.Distance(candidateHash)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: Could not compare hashes for %s: %v\n", candidatePath, err)
-			continue // Skip if comparison fails
+		if *videos {
+			printVideoMatches(videoMatches, limit)
 		}
-
-		// Check if similarity threshold is met
-		if distance <= distanceThreshold {
-			similarityPercent := calculateSimilarityPercent(distance, defaultHashSize)
-			// Ensure floating point inaccuracies don't show slightly below threshold
-			if similarityPercent >= *threshold {
-				fmt.Println("\n--- Match Found! ---")
-				fmt.Printf("Input Image:    '%s'\n", *inputImage)
-				fmt.Printf("Similar Image:  '%s'\n", candidatePath)
-				fmt.Printf("Hamming Distance: %d (Threshold <= %d)\n", distance, distanceThreshold)
-				fmt.Printf("Similarity:       %.2f%% (Threshold >= %.2f%%)\n", similarityPercent, *threshold)
-				fmt.Printf("Processed %d out of %d candidates before stopping.\n", processedCount, len(candidatePaths))
-				foundMatch = true
-				os.Exit(0) // Stop processing like python version
-			}
+		fmt.Printf("Indexed %d candidate files.\n", len(index.Nodes))
+		os.Exit(exitCode)
+	}
+
+	// --- Best Similar Image ---
+	if len(matches) > 0 {
+		match := matches[0]
+		fmt.Println("\n--- Match Found! ---")
+		fmt.Printf("Input Image:    '%s'\n", *inputImage)
+		fmt.Printf("Similar Image:  '%s'\n", match.Path)
+		fmt.Printf("Hamming Distance: %d (Threshold <= %d)\n", match.Distance, distanceThreshold)
+		fmt.Printf("Similarity:       %.2f%% (Threshold >= %.2f%%)\n", match.Similarity, *threshold)
+		if opts.Transforms {
+			fmt.Printf("Transform:        %s\n", match.Transform)
+		}
+		if spec.Color {
+			fmt.Printf("Colour:           %.2f%% (Threshold >= %.2f%%)\n", match.ColorSimilarity, opts.ColorThreshold)
+			fmt.Printf("Combined Score:   %.2f%%\n", match.Score)
+		}
+		if len(matches) > 1 {
+			fmt.Printf("%d more match(es) found, use -top or -all to list them.\n", len(matches)-1)
+		}
+		if spec.Regions {
+			printCropMatches(cropMatches, 1)
+		}
+		if *videos {
+			printVideoMatches(videoMatches, 1)
 		}
+		os.Exit(exitMatch)
+	}
+	if len(cropMatches) > 0 {
+		crop := cropMatches[0]
+		fmt.Println("\n--- Crop Found! ---")
+		fmt.Printf("Input Image:    '%s'\n", *inputImage)
+		fmt.Printf("Likely Crop Of: '%s'\n", crop.Path)
+		fmt.Printf("Hamming Distance: %d (Threshold <= %d)\n", crop.Distance, distanceThreshold)
+		fmt.Printf("Similarity:       %.2f%% (Threshold >= %.2f%%)\n", crop.Similarity, *threshold)
+		fmt.Printf("Overlap:          %.0f%% of the original\n", crop.Overlap*100)
+		if *videos {
+			printVideoMatches(videoMatches, 1)
+		}
+		os.Exit(exitMatch)
+	}
+	if len(videoMatches) > 0 {
+		video := videoMatches[0]
+		fmt.Println("\n--- Video Frame Found! ---")
+		fmt.Printf("Input Image:    '%s'\n", *inputImage)
+		fmt.Printf("Video:          '%s'\n", video.Path)
+		fmt.Printf("Timestamp:      %s\n", formatTimestamp(video.Timestamp))
+		fmt.Printf("Hamming Distance: %d (Threshold <= %d)\n", video.Distance, distanceThreshold)
+		fmt.Printf("Similarity:       %.2f%% (Threshold >= %.2f%%)\n", video.Similarity, *threshold)
+		if spec.Color {
+			fmt.Printf("Colour:           %.2f%% (Threshold >= %.2f%%)\n", video.ColorSimilarity, opts.ColorThreshold)
+			fmt.Printf("Combined Score:   %.2f%%\n", video.Score)
+		}
+		if len(videoMatches) > 1 {
+			fmt.Printf("%d more video(s) found, use -top or -all to list them.\n", len(videoMatches)-1)
+		}
+		os.Exit(exitMatch)
 	}
 
 	// --- No Match Found ---
-	if !foundMatch {
-		fmt.Printf("\nNo similar image found matching the threshold (>= %.2f%%)\n", *threshold)
-		fmt.Printf("Scanned %d candidate files.\n", processedCount)
-		os.Exit(0) // Exit normally
-	}
+	fmt.Printf("\nNo similar image found matching the threshold (>= %.2f%%)\n", *threshold)
+	fmt.Printf("Indexed %d candidate files.\n", len(index.Nodes))
+	os.Exit(exitNoMatch)
 }
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/mattanapol/image_manager/internal/image_helper"
+)
+
+// Output formats selectable with -format
+const (
+	formatText = "text"
+	formatJSON = "json"
+	formatCSV  = "csv"
+)
+
+// Exit codes, so scripts can branch on the outcome of a search
+const (
+	exitMatch   = 0 // At least one query has a match, a crop match or a video frame match, even if other queries failed
+	exitNoMatch = 1 // No query has a match and none failed
+	exitError   = 2 // Invalid arguments, the search could not run or no query matched and one could not be processed
+)
+
+// statusOut receives progress and status messages. It is switched to stderr for the
+// machine-readable formats so stdout only holds the results.
+var statusOut io.Writer = os.Stdout
+
+// fatalf logs the error and exits with exitError.
+func fatalf(format string, args ...any) {
+	log.Printf(format, args...)
+	os.Exit(exitError)
+}
+
+// parseOutputFormat validates the -format flag.
+func parseOutputFormat(format string) (string, error) {
+	switch format {
+	case formatText, formatJSON, formatCSV:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown output format %q (supported: text, json, csv)", format)
+}
+
+// exitCodeFor returns the exit code for the results of a search. A match takes precedence over
+// failed queries, which reportFailedQueries lists on stderr.
+func exitCodeFor(results []QueryResult) int {
+	code := exitNoMatch
+	for _, result := range results {
+		if result.hasMatch() {
+			return exitMatch
+		}
+		if result.Err != nil {
+			code = exitError
+		}
+	}
+	return code
+}
+
+// reportFailedQueries logs every query that could not be processed to stderr.
+func reportFailedQueries(results []QueryResult) {
+	for _, result := range results {
+		if result.Err != nil {
+			log.Printf("Error: query %s failed: %v", result.Query, result.Err)
+		}
+	}
+}
+
+// fileDetails are the size and dimensions reported for every image in the machine-readable formats.
+// Fields are left empty when the file can no longer be read.
+type fileDetails struct {
+	Size   int64 `json:"size"`
+	Width  int   `json:"width"`
+	Height int   `json:"height"`
+}
+
+// describeFile reads the size and dimensions of an image file.
+func describeFile(path string) fileDetails {
+	var details fileDetails
+	if info, err := os.Stat(path); err == nil {
+		details.Size = info.Size()
+	}
+	if config, err := image_helper.DecodeConfig(path); err == nil {
+		details.Width, details.Height = config.Width, config.Height
+	}
+	return details
+}
+
+type jsonReport struct {
+	Hash           string      `json:"hash"`
+	Threshold      float64     `json:"threshold"`
+	ColorThreshold *float64    `json:"colorThreshold,omitempty"` // Only with -color
+	MaxDistance    int         `json:"maxDistance"`
+	MatchedCount   int         `json:"matchedQueries"`
+	Queries        []jsonQuery `json:"queries"`
+}
+
+type jsonQuery struct {
+	Path string `json:"path"`
+	fileDetails
+	Error   string      `json:"error,omitempty"`
+	Matches []jsonMatch `json:"matches"`
+	Crops   []jsonCrop  `json:"crops,omitempty"`
+	Videos  []jsonVideo `json:"videos,omitempty"`
+}
+
+type jsonMatch struct {
+	Path string `json:"path"`
+	fileDetails
+	Distance        int      `json:"distance"`
+	Similarity      float64  `json:"similarity"`
+	ColorSimilarity *float64 `json:"colorSimilarity,omitempty"` // Only with -color
+	Score           float64  `json:"score"`
+	Transform       string   `json:"transform,omitempty"`
+}
+
+type jsonCrop struct {
+	Path string `json:"path"`
+	fileDetails
+	Distance   int     `json:"distance"`
+	Similarity float64 `json:"similarity"`
+	Overlap    float64 `json:"overlap"` // Percent of the candidate covered by the query
+}
+
+type jsonVideo struct {
+	Path            string   `json:"path"`
+	Size            int64    `json:"size"`
+	Timestamp       string   `json:"timestamp"` // hh:mm:ss.mmm of the closest keyframe
+	Seconds         float64  `json:"seconds"`
+	Distance        int      `json:"distance"`
+	Similarity      float64  `json:"similarity"`
+	ColorSimilarity *float64 `json:"colorSimilarity,omitempty"` // Only with -color
+	Score           float64  `json:"score"`
+	Transform       string   `json:"transform,omitempty"`
+}
+
+// reportedTransform returns the transform of a match, or "" when -rotations is not set.
+func reportedTransform(match Match, opts searchOptions) string {
+	if !opts.Transforms {
+		return ""
+	}
+	if match.Transform == "" {
+		return identityTransform
+	}
+	return match.Transform
+}
+
+// writeResults writes the results of a search in a machine-readable format.
+func writeResults(w io.Writer, format string, results []QueryResult, opts searchOptions) error {
+	switch format {
+	case formatJSON:
+		return writeJSONResults(w, results, opts)
+	case formatCSV:
+		return writeCSVResults(w, results, opts)
+	}
+	return fmt.Errorf("unsupported output format %q", format)
+}
+
+// writeJSONResults writes a single JSON document with every query and its matches.
+func writeJSONResults(w io.Writer, results []QueryResult, opts searchOptions) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(newJSONReport(results, opts))
+}
+
+// newJSONReport converts the results to their JSON form, reading the size and dimensions of
+// every reported file.
+func newJSONReport(results []QueryResult, opts searchOptions) jsonReport {
+	report := jsonReport{
+		Hash:        opts.Spec.String(),
+		Threshold:   opts.Threshold,
+		MaxDistance: opts.DistanceThreshold,
+		Queries:     make([]jsonQuery, 0, len(results)),
+	}
+	if opts.Spec.Color {
+		report.ColorThreshold = &opts.ColorThreshold
+	}
+	for _, result := range results {
+		query := jsonQuery{Path: result.Query, Matches: []jsonMatch{}}
+		if result.Err != nil {
+			query.Error = result.Err.Error()
+		} else {
+			query.fileDetails = describeFile(result.Query)
+		}
+		for _, match := range result.Matches {
+			reported := jsonMatch{
+				Path:        match.Path,
+				fileDetails: describeFile(match.Path),
+				Distance:    match.Distance,
+				Similarity:  match.Similarity,
+				Score:       match.Score,
+				Transform:   reportedTransform(match, opts),
+			}
+			if opts.Spec.Color {
+				reported.ColorSimilarity = &match.ColorSimilarity
+			}
+			query.Matches = append(query.Matches, reported)
+		}
+		for _, crop := range result.Crops {
+			query.Crops = append(query.Crops, jsonCrop{
+				Path:        crop.Path,
+				fileDetails: describeFile(crop.Path),
+				Distance:    crop.Distance,
+				Similarity:  crop.Similarity,
+				Overlap:     crop.Overlap * 100,
+			})
+		}
+		for _, video := range result.Videos {
+			reported := jsonVideo{
+				Path:       video.Path,
+				Size:       describeFile(video.Path).Size,
+				Timestamp:  formatTimestamp(video.Timestamp),
+				Seconds:    video.Timestamp.Seconds(),
+				Distance:   video.Distance,
+				Similarity: video.Similarity,
+				Score:      video.Score,
+				Transform:  reportedTransform(video.Match, opts),
+			}
+			if opts.Spec.Color {
+				reported.ColorSimilarity = &video.ColorSimilarity
+			}
+			query.Videos = append(query.Videos, reported)
+		}
+		if result.hasMatch() {
+			report.MatchedCount++
+		}
+		report.Queries = append(report.Queries, query)
+	}
+	return report
+}
+
+// csvHeaders are the columns of the CSV output. Every match, crop match and video match is a
+// row; a query without any gets a single row with kind "none" or "error".
+var csvHeaders = []string{"query", "kind", "rank", "path", "distance", "similarity", "color_similarity", "score", "transform", "overlap", "timestamp", "size", "width", "height", "error"}
+
+// writeCSVResults writes one row per match.
+func writeCSVResults(w io.Writer, results []QueryResult, opts searchOptions) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeaders); err != nil {
+		return err
+	}
+
+	formatFloat := func(value float64) string { return strconv.FormatFloat(value, 'f', 2, 64) }
+	detailColumns := func(path string) []string {
+		details := describeFile(path)
+		return []string{strconv.FormatInt(details.Size, 10), strconv.Itoa(details.Width), strconv.Itoa(details.Height)}
+	}
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			writer.Write([]string{result.Query, "error", "", "", "", "", "", "", "", "", "", "", "", "", result.Err.Error()})
+			continue
+		case !result.hasMatch():
+			writer.Write([]string{result.Query, "none", "", "", "", "", "", "", "", "", "", "", "", "", ""})
+			continue
+		}
+		colorSimilarity := func(match Match) string {
+			if !opts.Spec.Color {
+				return ""
+			}
+			return formatFloat(match.ColorSimilarity)
+		}
+		for i, match := range result.Matches {
+			row := []string{result.Query, "match", strconv.Itoa(i + 1), match.Path, strconv.Itoa(match.Distance),
+				formatFloat(match.Similarity), colorSimilarity(match), formatFloat(match.Score), reportedTransform(match, opts), "", ""}
+			writer.Write(append(append(row, detailColumns(match.Path)...), ""))
+		}
+		for i, crop := range result.Crops {
+			row := []string{result.Query, "crop", strconv.Itoa(i + 1), crop.Path, strconv.Itoa(crop.Distance),
+				formatFloat(crop.Similarity), "", "", "", formatFloat(crop.Overlap * 100), ""}
+			writer.Write(append(append(row, detailColumns(crop.Path)...), ""))
+		}
+		for i, video := range result.Videos {
+			// Videos have no image dimensions, only their size is reported
+			writer.Write([]string{result.Query, "video", strconv.Itoa(i + 1), video.Path, strconv.Itoa(video.Distance),
+				formatFloat(video.Similarity), colorSimilarity(video.Match), formatFloat(video.Score), reportedTransform(video.Match, opts), "",
+				formatTimestamp(video.Timestamp), strconv.FormatInt(describeFile(video.Path).Size, 10), "", "", ""})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+)
+
+// scanOptions describes how the search folder is scanned and hashed.
+type scanOptions struct {
+	Folder        string
+	CacheFile     string
+	Spec          hashSpec
+	Concurrency   int
+	Prune         bool          // Drop cache entries of files that no longer exist
+	Videos        bool          // Also sample the keyframes of the videos in the folder
+	FrameInterval time.Duration // Minimum time between two sampled keyframes of a video
+}
+
+// folderIndex is the hashed state of the search folder: the cache, the candidate images and the
+// similarity indexes over them. It is not modified once built, a rescan returns a new one, so a
+// folderIndex can be searched from several goroutines.
+type folderIndex struct {
+	Cache          *hash_helper.Cache
+	CandidatePaths []string
+	Index          *hash_helper.BKTree
+	CropIndex      *hash_helper.BKTree // Only built when Spec.Regions is set
+	VideoPaths     []string
+	FrameIndex     *hash_helper.BKTree // Only built when Videos is set
+	ScannedAt      time.Time
+	readOnlyCache  bool // The cache file was written by a newer version and must not be overwritten
+}
+
+// openFolderIndex walks the search folder, loads the cache and hashes the new or changed images.
+func openFolderIndex(scan scanOptions) (*folderIndex, error) {
+	candidatePaths, videoPaths, err := findFolderFiles(scan)
+	if err != nil {
+		return nil, err
+	}
+
+	readOnlyCache := false
+	cache, err := hash_helper.Load(scan.CacheFile, scan.Folder, candidatePaths)
+	if err != nil {
+		// hash_helper.Load already prints warnings, maybe just log fatal if it's critical
+		log.Printf("Warning: Proceeding without cache due to error: %v", err)
+		cache = hash_helper.NewCache() // Ensure it's initialized
+		// Never overwrite a cache we could not read because it comes from a newer version
+		readOnlyCache = errors.Is(err, hash_helper.ErrNewerCacheVersion)
+	}
+	return buildFolderIndex(scan, cache, candidatePaths, videoPaths, readOnlyCache), nil
+}
+
+// rescan walks the search folder again and returns a new index in which only new or changed
+// images were hashed. The receiver is left untouched so it can be searched in the meantime.
+func (f *folderIndex) rescan(scan scanOptions) (*folderIndex, error) {
+	candidatePaths, videoPaths, err := findFolderFiles(scan)
+	if err != nil {
+		return nil, err
+	}
+	return buildFolderIndex(scan, f.Cache.Clone(), candidatePaths, videoPaths, f.readOnlyCache), nil
+}
+
+// findFolderFiles walks the search folder for the candidate images and, with -videos, the videos.
+func findFolderFiles(scan scanOptions) ([]string, []string, error) {
+	candidatePaths, err := findImageFiles(scan.Folder)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error finding image files: %w", err)
+	}
+	if !scan.Videos {
+		return candidatePaths, nil, nil
+	}
+	videoPaths, err := findVideoFiles(scan.Folder)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error finding video files: %w", err)
+	}
+	return candidatePaths, videoPaths, nil
+}
+
+// buildFolderIndex hashes the candidates missing from the cache, builds or reuses the indexes
+// and saves the cache.
+func buildFolderIndex(scan scanOptions, cache *hash_helper.Cache, candidatePaths []string, videoPaths []string, readOnlyCache bool) *folderIndex {
+	if len(candidatePaths) == 0 {
+		fmt.Fprintln(statusOut, "No potential image files found in the search folder.")
+	}
+	if scan.Prune {
+		pruned := hash_helper.PruneMissing(cache.Entries, append(slices.Clone(candidatePaths), videoPaths...))
+		fmt.Fprintf(statusOut, "Pruned %d cache entries of missing files.\n", pruned)
+	}
+
+	// --- Calculate Hashes for Candidate Images (with Concurrency) ---
+	cache.Entries = hashImages(candidatePaths, cache.Entries, max(scan.Concurrency, 1), scan.Spec)
+	if scan.Videos {
+		cache.Entries = hashVideos(videoPaths, cache.Entries, scan.Spec, scan.FrameInterval)
+	}
+
+	// --- Build or Reuse the Similarity Index ---
+	folder := &folderIndex{Cache: cache, CandidatePaths: candidatePaths, VideoPaths: videoPaths, ScannedAt: time.Now(), readOnlyCache: readOnlyCache}
+	folder.Index = ensureIndex(cache, candidatePaths, scan.Spec)
+	if scan.Spec.Regions {
+		folder.CropIndex = ensureCropIndex(cache, candidatePaths, scan.Spec)
+	}
+	if scan.Videos {
+		folder.FrameIndex = ensureFrameIndex(cache, videoPaths, scan.Spec)
+	}
+
+	// --- Save Hashes to Cache ---
+	if readOnlyCache {
+		log.Printf("Warning: Not saving cache file %s, it was written by a newer version", scan.CacheFile)
+	} else if err := hash_helper.Save(scan.CacheFile, scan.Folder, cache); err != nil {
+		log.Printf("Warning: Could not save cache file %s: %v", scan.CacheFile, err)
+	}
+	return folder
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+)
+
+const (
+	// Default address of the serve mode
+	defaultServeAddr = "localhost:8080"
+	// Largest accepted upload, bigger requests are rejected before they are read entirely
+	maxUploadSize = 64 << 20
+	// Name of the multipart form field holding the image in a search request
+	uploadFieldName = "image"
+)
+
+// searchServer answers reverse image searches over HTTP from a folder index kept in memory.
+// Searches share the current index under a read lock; a rescan builds the next index without
+// holding the lock and only takes the write lock to swap it in.
+type searchServer struct {
+	scan  scanOptions
+	opts  searchOptions
+	limit int // Default number of matches returned per search, 0 for all
+
+	mu       sync.RWMutex
+	folder   *folderIndex
+	rescanMu sync.Mutex // Serialises rescans, they would hash the same files
+}
+
+// rescanResponse is returned by POST /rescan and GET /status.
+type rescanResponse struct {
+	Folder    string    `json:"folder"`
+	Hash      string    `json:"hash"`
+	Images    int       `json:"images"`
+	Videos    int       `json:"videos,omitempty"` // Videos with sampled keyframes, only with -videos
+	ScannedAt time.Time `json:"scannedAt"`
+	Duration  string    `json:"duration,omitempty"`
+}
+
+// runServeCommand runs `image_similar_finder serve`.
+func runServeCommand(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", defaultServeAddr, "Address to listen on.")
+	searchFolder := flags.String("folder", "", "Path to the folder to search (required)")
+	threshold := flags.Float64("threshold", 90.0, "Default similarity threshold percentage (0-100), a search can override it with ?threshold=.")
+	concurrency := flags.Int("concurrency", runtime.NumCPU()-1, "Number of concurrent processes used to hash the folder.")
+	cacheFile := flags.String("cache", "", fmt.Sprintf("Path to the cache file. Defaults to '%s' in the search folder.", hash_helper.DefaultCacheFileName))
+	algo := flags.String("algo", defaultHashAlgorithms, "Hash algorithm: average, difference or perception. Combine with '+' (e.g. perception+difference) to require a match for every algorithm.")
+	hashWidth := flags.Int("hash-width", defaultHashWidth, "Hash width, each hash has width*width bits (8 = 64 bits, 16 = 256 bits, the largest).")
+	top := flags.Int("top", 10, "Default number of matches returned per search (0 for all), a search can override it with ?top=.")
+	rotations := flags.Bool("rotations", false, "Also match the 8 rotations and flips of the uploaded image.")
+	crops := flags.Bool("crops", false, "Index hashes of overlapping regions of every image and report images the upload is likely a crop of.")
+	color := flags.Bool("color", false, "Also compare colour histograms, a match has to pass both the similarity and the colour threshold and is ranked by their combined score.")
+	colorThreshold := flags.Float64("color-threshold", defaultColorThreshold, "Default colour similarity threshold percentage (0-100), a search can override it with ?color-threshold=.")
+	prune := flags.Bool("prune", false, "Drop cache entries of files that no longer exist on every scan.")
+	videos := flags.Bool("videos", false, "Also sample the keyframes of the videos in the folder with ffmpeg and report the video and timestamp of the closest frame.")
+	frameInterval := flags.Duration("frame-interval", defaultFrameInterval, "Minimum time between two sampled keyframes of a video, used with -videos.")
+	flags.Parse(args)
+
+	if *searchFolder == "" {
+		fatalf("Error: Search folder path (-folder) is required.")
+	}
+	if info, err := os.Stat(*searchFolder); err != nil || !info.IsDir() {
+		fatalf("Error: Search folder not found or is not a directory: %s", *searchFolder)
+	}
+	if *top < 0 {
+		fatalf("Error: -top must be a positive number.")
+	}
+	spec, err := parseHashSpec(*algo, *hashWidth)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	spec.Regions = *crops
+	spec.Color = *color
+	if *colorThreshold < 0 || *colorThreshold > 100 {
+		fatalf("Error: -color-threshold must be between 0 and 100.")
+	}
+	if *frameInterval < 0 {
+		fatalf("Error: -frame-interval must not be negative.")
+	}
+	distanceThreshold, err := convertPercentToDistance(*threshold, spec.Bits())
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	cacheFilePath := *cacheFile
+	if cacheFilePath == "" {
+		cacheFilePath = filepath.Join(*searchFolder, hash_helper.DefaultCacheFileName)
+	}
+
+	server := &searchServer{
+		scan: scanOptions{Folder: *searchFolder, CacheFile: cacheFilePath, Spec: spec, Concurrency: *concurrency, Prune: *prune,
+			Videos: *videos, FrameInterval: *frameInterval},
+		opts: searchOptions{Spec: spec, Threshold: *threshold, DistanceThreshold: distanceThreshold, Transforms: *rotations,
+			ColorThreshold: *colorThreshold},
+		limit: *top,
+	}
+	server.folder, err = openFolderIndex(server.scan)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /search", server.handleSearch)
+	mux.HandleFunc("POST /rescan", server.handleRescan)
+	mux.HandleFunc("GET /status", server.handleStatus)
+
+	fmt.Fprintf(statusOut, "\nServing %d images of %s on http://%s (POST /search, POST /rescan, GET /status)\n",
+		len(server.folder.Index.Nodes), *searchFolder, *addr)
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		fatalf("Error: %v", err)
+	}
+}
+
+// currentFolder returns the index searches run against.
+func (s *searchServer) currentFolder() *folderIndex {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.folder
+}
+
+// handleSearch ranks the uploaded image against the folder index. The image is sent either as
+// the "image" field of a multipart form or as the raw request body. The optional top, threshold
+// and color-threshold query parameters override the server defaults.
+func (s *searchServer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	opts, limit, err := s.searchParameters(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	uploadPath, name, err := saveUpload(r)
+	if err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeJSONError(w, status, err)
+		return
+	}
+	defer os.Remove(uploadPath)
+
+	queries, err := calculateTransformHashes(uploadPath, opts.Spec, opts.Transforms)
+	if err != nil {
+		// Report the error with the uploaded name instead of the temporary path
+		message := strings.ReplaceAll(err.Error(), uploadPath, name)
+		writeJSONError(w, http.StatusUnprocessableEntity, fmt.Errorf("could not process image: %s", message))
+		return
+	}
+
+	folder := s.currentFolder()
+	result := QueryResult{Query: uploadPath}
+	result.Matches = rankTransformedMatches(queries, "", folder.Index, folder.Cache.Entries, opts)
+	if opts.Spec.Regions {
+		result.Crops = findCrops(queries[0].Hashes[0], "", folder.CropIndex, folder.Cache.Entries, opts, result.Matches)
+	}
+	if s.scan.Videos {
+		result.Videos = findVideoFrames(queries, folder.FrameIndex, folder.Cache.Entries, opts)
+	}
+	if limit > 0 {
+		result.Matches = result.Matches[:min(limit, len(result.Matches))]
+		result.Crops = result.Crops[:min(limit, len(result.Crops))]
+		result.Videos = result.Videos[:min(limit, len(result.Videos))]
+	}
+
+	// Describe the upload while the temporary file still exists, then report it by its name
+	report := newJSONReport([]QueryResult{result}, opts)
+	report.Queries[0].Path = name
+	log.Printf("Search %q: %d match(es), %d crop(s), %d video(s)", name, len(result.Matches), len(result.Crops), len(result.Videos))
+	writeJSON(w, http.StatusOK, report)
+}
+
+// searchParameters applies the top and threshold query parameters to the server defaults.
+func (s *searchServer) searchParameters(r *http.Request) (searchOptions, int, error) {
+	opts, limit := s.opts, s.limit
+	if value := r.URL.Query().Get("top"); value != "" {
+		top, err := strconv.Atoi(value)
+		if err != nil || top < 0 {
+			return opts, 0, fmt.Errorf("top must be a positive number, got %q", value)
+		}
+		limit = top
+	}
+	if value := r.URL.Query().Get("threshold"); value != "" {
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return opts, 0, fmt.Errorf("invalid threshold %q", value)
+		}
+		distanceThreshold, err := convertPercentToDistance(threshold, opts.Spec.Bits())
+		if err != nil {
+			return opts, 0, err
+		}
+		opts.Threshold, opts.DistanceThreshold = threshold, distanceThreshold
+	}
+	if value := r.URL.Query().Get("color-threshold"); value != "" {
+		colorThreshold, err := strconv.ParseFloat(value, 64)
+		if err != nil || colorThreshold < 0 || colorThreshold > 100 {
+			return opts, 0, fmt.Errorf("color-threshold must be between 0 and 100, got %q", value)
+		}
+		opts.ColorThreshold = colorThreshold
+	}
+	return opts, limit, nil
+}
+
+// saveUpload writes the uploaded image to a temporary file, so it is decoded exactly like the
+// files of the folder, and returns its path and the name to report it by.
+func saveUpload(r *http.Request) (string, string, error) {
+	var (
+		body io.Reader = r.Body
+		name           = "upload"
+	)
+	if isMultipart(r) {
+		file, header, err := r.FormFile(uploadFieldName)
+		if err != nil {
+			return "", "", fmt.Errorf("missing %q form field: %w", uploadFieldName, err)
+		}
+		defer file.Close()
+		body, name = file, header.Filename
+	}
+
+	tmpFile, err := os.CreateTemp("", "image_similar_finder-*"+filepath.Ext(name))
+	if err != nil {
+		return "", "", err
+	}
+	defer tmpFile.Close()
+	if _, err := io.Copy(tmpFile, body); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", "", err
+	}
+	return tmpFile.Name(), name, nil
+}
+
+// isMultipart reports whether the request body is a multipart form.
+func isMultipart(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// handleRescan walks the folder again and hashes new or changed files. Searches keep using the
+// previous index until the new one is ready.
+func (s *searchServer) handleRescan(w http.ResponseWriter, r *http.Request) {
+	s.rescanMu.Lock()
+	defer s.rescanMu.Unlock()
+
+	start := time.Now()
+	folder, err := s.currentFolder().rescan(s.scan)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.mu.Lock()
+	s.folder = folder
+	s.mu.Unlock()
+
+	response := s.describe(folder)
+	response.Duration = time.Since(start).String()
+	log.Printf("Rescanned %s: %d images in %s", s.scan.Folder, response.Images, response.Duration)
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleStatus reports the size and age of the index.
+func (s *searchServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.describe(s.currentFolder()))
+}
+
+func (s *searchServer) describe(folder *folderIndex) rescanResponse {
+	return rescanResponse{
+		Folder:    s.scan.Folder,
+		Hash:      s.scan.Spec.String(),
+		Images:    len(folder.Index.Nodes),
+		Videos:    len(folder.VideoPaths),
+		ScannedAt: folder.ScannedAt,
+	}
+}
+
+// writeJSON writes value as the JSON response body.
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Warning: Could not write response: %v", err)
+	}
+}
+
+// writeJSONError writes an {"error": "..."} response.
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/corona10/goimagehash"
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+)
+
+// cacheStats summarises a cache file for the `cache stats` command.
+type cacheStats struct {
+	DiskSize    int64
+	Entries     int
+	Fresh       int            // Entries whose file is unchanged since it was hashed
+	Changed     int            // Entries whose file was modified since it was hashed
+	Missing     int            // Entries whose file no longer exists (dropped by -prune)
+	Hashes      map[string]int // Number of entries per stored hash, e.g. "perception/64"
+	WithRegions int
+	WithColor   int
+	Videos      int // Entries holding the keyframes of a video
+	Frames      int
+	IndexNodes  int
+	CropNodes   int
+	FrameNodes  int
+}
+
+// runCacheCommand runs `image_similar_finder cache <command>`.
+func runCacheCommand(args []string) {
+	if len(args) == 0 || args[0] != "stats" {
+		fatalf("Usage: image_similar_finder cache stats -folder <folder> [-cache <file>]")
+	}
+
+	flags := flag.NewFlagSet("cache stats", flag.ExitOnError)
+	searchFolder := flags.String("folder", "", "Path to the search folder the cache belongs to (required)")
+	cacheFile := flags.String("cache", "", fmt.Sprintf("Path to the cache file. Defaults to '%s' in the search folder.", hash_helper.DefaultCacheFileName))
+	flags.Parse(args[1:])
+
+	if *searchFolder == "" {
+		fatalf("Error: Search folder path (-folder) is required.")
+	}
+	cacheFilePath := *cacheFile
+	if cacheFilePath == "" {
+		cacheFilePath = filepath.Join(*searchFolder, hash_helper.DefaultCacheFileName)
+	}
+	info, err := os.Stat(cacheFilePath)
+	if err != nil {
+		fatalf("Error: Cache file not found: %s", cacheFilePath)
+	}
+
+	cache, err := hash_helper.Load(cacheFilePath, *searchFolder, nil)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	stats := collectCacheStats(cache)
+	stats.DiskSize = info.Size()
+	printCacheStats(cacheFilePath, stats)
+}
+
+// collectCacheStats stats the file of every entry to count the stale ones.
+func collectCacheStats(cache *hash_helper.Cache) cacheStats {
+	stats := cacheStats{Entries: len(cache.Entries), Hashes: make(map[string]int)}
+	for path, entry := range cache.Entries {
+		info, err := os.Stat(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			stats.Missing++
+		case err == nil && entry.IsFresh(info):
+			stats.Fresh++
+		default:
+			stats.Changed++
+		}
+		for _, stored := range entry.Hashes {
+			stats.Hashes[fmt.Sprintf("%s/%d", hashKindName(stored.Kind), stored.Bits)]++
+		}
+		if len(entry.Regions) > 0 {
+			stats.WithRegions++
+		}
+		if hasColor(entry) {
+			stats.WithColor++
+		}
+		if entry.FrameLayout != 0 {
+			stats.Videos++
+			stats.Frames += len(entry.Frames)
+		}
+	}
+	if cache.Index != nil {
+		stats.IndexNodes = len(cache.Index.Nodes)
+	}
+	if cache.CropIndex != nil {
+		stats.CropNodes = len(cache.CropIndex.Nodes)
+	}
+	if cache.FrameIndex != nil {
+		stats.FrameNodes = len(cache.FrameIndex.Nodes)
+	}
+	return stats
+}
+
+// hashKindName returns the -algo name of a hash kind.
+func hashKindName(kind goimagehash.Kind) string {
+	if name, known := hash_helper.KindName(kind); known {
+		return name
+	}
+	return fmt.Sprintf("kind%d", kind)
+}
+
+// printCacheStats prints the summary of a cache file.
+func printCacheStats(cacheFile string, stats cacheStats) {
+	fmt.Printf("Cache file:     %s\n", cacheFile)
+	fmt.Printf("Size on disk:   %.2f MB (%d bytes)\n", float64(stats.DiskSize)/(1024*1024), stats.DiskSize)
+	fmt.Printf("Entries:        %d\n", stats.Entries)
+	fmt.Printf("  Fresh:        %d\n", stats.Fresh)
+	fmt.Printf("  Stale:        %d (%d changed, %d missing; run with -prune to drop missing files)\n",
+		stats.Changed+stats.Missing, stats.Changed, stats.Missing)
+	fmt.Printf("  With regions: %d\n", stats.WithRegions)
+	fmt.Printf("  With colour:  %d\n", stats.WithColor)
+	fmt.Printf("  Videos:       %d (%d keyframes)\n", stats.Videos, stats.Frames)
+
+	names := make([]string, 0, len(stats.Hashes))
+	for name := range stats.Hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hashes := make([]string, len(names))
+	for i, name := range names {
+		hashes[i] = fmt.Sprintf("%s (%d)", name, stats.Hashes[name])
+	}
+	fmt.Printf("Stored hashes:  %s\n", strings.Join(hashes, ", "))
+	fmt.Printf("Index:          %d images, crop index: %d regions, frame index: %d keyframes\n", stats.IndexNodes, stats.CropNodes, stats.FrameNodes)
+}
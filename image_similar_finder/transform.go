@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/corona10/goimagehash"
+	"github.com/disintegration/imaging"
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+)
+
+// identityTransform is the name reported for a match of the query image as it is.
+const identityTransform = "none"
+
+// queryTransform is one of the 8 rotations and flips (the dihedral group of the square)
+// applied to a query image. Rotations are counter-clockwise like in the imaging package.
+type queryTransform struct {
+	Name  string
+	apply func(img image.Image) *image.NRGBA
+}
+
+// queryTransforms lists every transform tried with -rotations, the identity first so it wins ties.
+var queryTransforms = []queryTransform{
+	{Name: identityTransform},
+	{Name: "rotate90", apply: imaging.Rotate90},
+	{Name: "rotate180", apply: imaging.Rotate180},
+	{Name: "rotate270", apply: imaging.Rotate270},
+	{Name: "flipH", apply: imaging.FlipH},
+	{Name: "flipV", apply: imaging.FlipV},
+	{Name: "transpose", apply: imaging.Transpose},
+	{Name: "transverse", apply: imaging.Transverse},
+}
+
+// transformedHashes are the hashes of a query image after applying a transform.
+type transformedHashes struct {
+	Transform string
+	Hashes    []*goimagehash.ExtImageHash
+	Color     []float32 // Colour histogram of the query with -color, the same for every transform
+}
+
+// signature returns the transformed query as a signature to rank candidates with.
+func (t transformedHashes) signature() *imageSignature {
+	return &imageSignature{Hashes: t.Hashes, Color: t.Color}
+}
+
+// calculateTransformHashes hashes the query image once per transform, or only as it is when
+// transforms are disabled. Rotations and flips do not change the colours, so the colour
+// histogram is only computed once.
+func calculateTransformHashes(imagePath string, spec hashSpec, withTransforms bool) ([]transformedHashes, error) {
+	img, err := decodeImage(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var color []float32
+	if spec.Color {
+		color = colorHistogram(img)
+	}
+
+	transforms := queryTransforms[:1]
+	if withTransforms {
+		transforms = queryTransforms
+	}
+
+	result := make([]transformedHashes, 0, len(transforms))
+	for _, transform := range transforms {
+		transformed := img
+		if transform.apply != nil {
+			transformed = transform.apply(img)
+		}
+		hashes := hashImage(transformed, spec, imagePath)
+		if hashes == nil {
+			return nil, fmt.Errorf("could not hash %s with transform %s", imagePath, transform.Name)
+		}
+		result = append(result, transformedHashes{Transform: transform.Name, Hashes: hashes, Color: color})
+	}
+	return result, nil
+}
+
+// rankTransformedMatches runs rankMatches for every transformed query and keeps the best
+// transform per candidate, ranked like sortMatches (by score when -color is set), so each
+// candidate is reported once with the transform that matched.
+func rankTransformedMatches(queries []transformedHashes, absInputImagePath string, index *hash_helper.BKTree,
+	imageHashes hash_helper.Entries, opts searchOptions) []Match {
+	best := make(map[string]Match)
+	for _, query := range queries {
+		for _, match := range rankMatches(query.signature(), absInputImagePath, index, imageHashes, opts) {
+			match.Transform = query.Transform
+			if current, exists := best[match.Path]; !exists || betterMatch(match, current) {
+				best[match.Path] = match
+			}
+		}
+	}
+
+	matches := make([]Match, 0, len(best))
+	for _, match := range best {
+		matches = append(matches, match)
+	}
+	sortMatches(matches)
+	return matches
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/mattanapol/image_manager/internal/common"
+	"github.com/mattanapol/image_manager/internal/hash_helper"
+	"github.com/mattanapol/image_manager/internal/video_helper"
+)
+
+const (
+	// Default for -frame-interval
+	defaultFrameInterval = 2 * time.Second
+	// Keyframes are scaled to this size before hashing. The perception hash downscales to
+	// width*width pixels, so this covers hash widths up to 16.
+	videoFrameSize = 256
+	// Bump when the frame sampling changes so cached frames are recomputed
+	videoFrameLayout = 1
+)
+
+// VideoMatch describes a video with a keyframe within the thresholds of the query. The embedded
+// Match is the one of the closest frame, its Path is the video.
+type VideoMatch struct {
+	Match
+	Timestamp time.Duration // Timestamp of the closest frame
+}
+
+// hasFrames reports whether the entry holds keyframes sampled at interval with every hash and
+// the colour histogram required by spec. A video without keyframes has an empty Frames.
+func hasFrames(e *hash_helper.Entry, spec hashSpec, interval time.Duration) bool {
+	if e.FrameLayout != videoFrameLayout || e.FrameInterval != interval {
+		return false
+	}
+	if len(e.Frames) == 0 {
+		return true
+	}
+	frame := frameEntry(e, 0)
+	return hashesFor(frame, spec) != nil && (!spec.Color || hasColor(frame))
+}
+
+// frameEntry returns the hashes of the i-th frame as a cache entry, so frames are scored
+// exactly like images.
+func frameEntry(e *hash_helper.Entry, i int) *hash_helper.Entry {
+	frame := e.Frames[i]
+	return &hash_helper.Entry{Hashes: frame.Hashes, Color: frame.Color, ColorLayout: e.ColorLayout}
+}
+
+// isVideoFile checks if a file is a video by the extensions of the video tools.
+func isVideoFile(filePath string) bool {
+	return common.IsVideo(filePath)
+}
+
+// findVideoFiles recursively finds all video files in the given folder.
+func findVideoFiles(folderPath string) ([]string, error) {
+	var videoFiles []string
+	err := hash_helper.Walk(folderPath, nil, func(path string, d fs.DirEntry) {
+		if isVideoFile(path) {
+			videoFiles = append(videoFiles, path)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking the path %q: %w", folderPath, err)
+	}
+	fmt.Fprintf(statusOut, "Found %d video files to sample.\n", len(videoFiles))
+	return videoFiles, nil
+}
+
+// calculateFrameHashes samples the keyframes of a video with ffmpeg and signs every frame like
+// calculateHash signs an image.
+func calculateFrameHashes(videoPath string, spec hashSpec, interval time.Duration) ([]hash_helper.FrameHash, error) {
+	var frames []hash_helper.FrameHash
+	timestamps, err := video_helper.Keyframes(videoPath, video_helper.KeyframeOptions{MinInterval: interval, Size: videoFrameSize},
+		func(frame *image.NRGBA) error {
+			signature := signImage(frame, spec, videoPath)
+			if signature == nil {
+				return fmt.Errorf("could not hash frame %d", len(frames))
+			}
+			hash := hash_helper.FrameHash{Color: signature.Color}
+			for _, h := range signature.Hashes {
+				hash.Hashes = append(hash.Hashes, hash_helper.StoredHash{Hash: h.GetHash(), Kind: h.GetKind(), Bits: h.Bits()})
+			}
+			frames = append(frames, hash)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	for i := range frames {
+		frames[i].Timestamp = timestamps[i]
+	}
+	return frames, nil
+}
+
+// hashVideos makes sure imageHashes holds fresh keyframes for every video. Videos are sampled
+// one at a time, ffmpeg already decodes with several threads.
+func hashVideos(videoPaths []string, imageHashes hash_helper.Entries, spec hashSpec, interval time.Duration) hash_helper.Entries {
+	// Frames are only compared as a whole
+	frameSpec := spec
+	frameSpec.Regions = false
+
+	fmt.Fprintf(statusOut, "Sampling video keyframes (one every %v at most)...\n", interval)
+	has := func(entry *hash_helper.Entry) bool { return hasFrames(entry, frameSpec, interval) }
+	hash_helper.Update(imageHashes, videoPaths, 1, has, func(path string) (func(*hash_helper.Entry), error) {
+		frames, err := calculateFrameHashes(path, frameSpec, interval)
+		if err != nil {
+			return nil, fmt.Errorf("sampling failed: %w", err)
+		}
+		return func(entry *hash_helper.Entry) {
+			// Video entries hold no other hashes
+			*entry = hash_helper.Entry{
+				Frames:        frames,
+				FrameInterval: interval,
+				FrameLayout:   videoFrameLayout,
+				ColorLayout:   colorHistogramLayout,
+				Size:          entry.Size,
+				ModTime:       entry.ModTime,
+			}
+		}, nil
+	})
+	return imageHashes
+}
+
+// frameIndexSpecKey identifies the hash the frame index is built on.
+func frameIndexSpecKey(spec hashSpec) string {
+	return "frame:" + indexSpecKey(spec)
+}
+
+// frameFingerprint is the indexFingerprint equivalent for the keyframes of the videos.
+func frameFingerprint(videoPaths []string, imageHashes hash_helper.Entries, spec hashSpec) uint64 {
+	var fingerprint uint64
+	for _, path := range videoPaths {
+		entry := imageHashes[path]
+		if entry == nil {
+			continue
+		}
+		for i := range entry.Frames {
+			if hash := indexedHash(frameEntry(entry, i), spec); hash != nil {
+				fingerprint ^= hash_helper.NodeFingerprint(path, int32(i), hash)
+			}
+		}
+	}
+	return fingerprint
+}
+
+// ensureFrameIndex returns the persisted frame index when it still matches the videos, or
+// builds a new one over their keyframes and stores it in the cache.
+func ensureFrameIndex(cache *hash_helper.Cache, videoPaths []string, spec hashSpec) *hash_helper.BKTree {
+	fingerprint := frameFingerprint(videoPaths, cache.Entries, spec)
+	if cache.FrameIndex != nil && cache.FrameIndex.Spec == frameIndexSpecKey(spec) && cache.FrameIndex.Fingerprint == fingerprint {
+		fmt.Fprintf(statusOut, "Using cached frame index (%d frames).\n", len(cache.FrameIndex.Nodes))
+		return cache.FrameIndex
+	}
+
+	fmt.Fprintln(statusOut, "Building frame index...")
+	start := time.Now()
+	paths := make([]string, 0, len(videoPaths))
+	for _, path := range videoPaths {
+		if entry := cache.Entries[path]; entry != nil && len(entry.Frames) > 0 {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	tree := &hash_helper.BKTree{Spec: frameIndexSpecKey(spec), Fingerprint: fingerprint}
+	for _, path := range paths {
+		entry := cache.Entries[path]
+		for i := range entry.Frames {
+			if hash := indexedHash(frameEntry(entry, i), spec); hash != nil {
+				tree.Insert(hash_helper.Node{Path: path, Region: int32(i), Hash: hash})
+			}
+		}
+	}
+	cache.FrameIndex = tree
+	fmt.Fprintf(statusOut, "Indexed %d frames of %d videos in %v.\n", len(tree.Nodes), len(paths), time.Since(start))
+	return tree
+}
+
+// findVideoFrames looks up every query in the frame index and returns the videos with a matching
+// keyframe, the closest frame per video, sorted like sortMatches.
+func findVideoFrames(queries []transformedHashes, frameIndex *hash_helper.BKTree, imageHashes hash_helper.Entries, opts searchOptions) []VideoMatch {
+	best := make(map[string]VideoMatch)
+	for _, query := range queries {
+		signature := query.signature()
+		results, _ := frameIndex.Query(signature.Hashes[0].GetHash(), opts.DistanceThreshold)
+		for _, result := range results {
+			entry := imageHashes[result.Path]
+			if entry == nil || int(result.Region) >= len(entry.Frames) {
+				continue
+			}
+			// Re-check with every algorithm of the spec, the index only covers the first one
+			match, ok := scoreCandidate(signature, result.Path, frameEntry(entry, int(result.Region)), opts)
+			if !ok {
+				continue
+			}
+			match.Transform = query.Transform
+			video := VideoMatch{Match: match, Timestamp: entry.Frames[result.Region].Timestamp}
+			current, exists := best[result.Path]
+			if !exists || video.Score > current.Score || (video.Score == current.Score && video.Timestamp < current.Timestamp) {
+				best[result.Path] = video
+			}
+		}
+	}
+
+	videos := make([]VideoMatch, 0, len(best))
+	for _, video := range best {
+		videos = append(videos, video)
+	}
+	sort.Slice(videos, func(i, j int) bool {
+		if videos[i].Score != videos[j].Score {
+			return videos[i].Score > videos[j].Score
+		}
+		if videos[i].Distance != videos[j].Distance {
+			return videos[i].Distance < videos[j].Distance
+		}
+		return videos[i].Path < videos[j].Path
+	})
+	return videos
+}
+
+// formatTimestamp formats a frame timestamp as hh:mm:ss.mmm, the notation ffmpeg -ss accepts.
+func formatTimestamp(timestamp time.Duration) string {
+	milliseconds := timestamp.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", milliseconds/3600000, milliseconds/60000%60, milliseconds/1000%60, milliseconds%1000)
+}
+
+// printVideoMatches prints up to limit video matches (all of them if limit <= 0).
+func printVideoMatches(videos []VideoMatch, limit int) {
+	if len(videos) == 0 {
+		fmt.Println("\nNo video found with a matching keyframe.")
+		return
+	}
+	if limit > 0 && len(videos) > limit {
+		videos = videos[:limit]
+	}
+
+	fmt.Printf("\n--- Input Matches a Keyframe of %d Video(s) ---\n", len(videos))
+	fmt.Printf("%8s  %10s  %12s  %s\n", "Distance", "Similarity", "Timestamp", "Path")
+	for _, video := range videos {
+		fmt.Printf("%8d  %9.2f%%  %12s  %s\n", video.Distance, video.Similarity, formatTimestamp(video.Timestamp), video.Path)
+	}
+}
@@ -1,9 +1,13 @@
 package common
 
-import "strings"
+import (
+	"path/filepath"
+	"strings"
+)
 
 var (
-	skipFolderList = []string{"$RECYCLE.BIN", ".Spotlight", ".fseventsd"}
+	skipFolderList  = []string{"$RECYCLE.BIN", ".Spotlight", ".fseventsd"}
+	videoExtensions = []string{".mp4", ".mkv", ".avi", ".mov", ".flv", ".wmv", ".mts"}
 )
 
 func ShouldSkipFolder(path string) bool {
@@ -14,3 +18,15 @@ func ShouldSkipFolder(path string) bool {
 	}
 	return false
 }
+
+// IsVideo reports whether the file has one of the video extensions handled by the video tools.
+func IsVideo(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, videoExt := range videoExtensions {
+		if ext == videoExt {
+			return true
+		}
+	}
+
+	return false
+}
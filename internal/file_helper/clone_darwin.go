@@ -0,0 +1,21 @@
+//go:build darwin
+
+package file_helper
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates dst as a clone of src with clonefile (APFS).
+func cloneFile(src, dst string) error {
+	err := unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW)
+	switch {
+	case errors.Is(err, unix.EXDEV):
+		return ErrCrossDevice
+	case errors.Is(err, unix.ENOTSUP):
+		return ErrCloneNotSupported
+	}
+	return err
+}
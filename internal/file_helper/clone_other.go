@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package file_helper
+
+// cloneFile is not implemented on this system.
+func cloneFile(src, dst string) error {
+	return ErrCloneNotSupported
+}
@@ -0,0 +1,167 @@
+package file_helper
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+var (
+	// ErrCrossDevice is returned when a file cannot be linked or cloned because it is on another
+	// device than its target.
+	ErrCrossDevice = errors.New("files are on different devices")
+	// ErrCloneNotSupported is returned by ReplaceWithClone when the system or file system cannot
+	// clone files.
+	ErrCloneNotSupported = errors.New("file system does not support reflink clones")
+	// ErrContentDiffers is returned when a file is not replaced because its content differs from
+	// its target, or it was modified while it was compared.
+	ErrContentDiffers = errors.New("content differs from the target")
+)
+
+// SameContent compares two files byte by byte.
+func SameContent(a, b string) (bool, error) {
+	fileA, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fileA.Close()
+	fileB, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fileB.Close()
+
+	infoA, err := fileA.Stat()
+	if err != nil {
+		return false, err
+	}
+	infoB, err := fileB.Stat()
+	if err != nil {
+		return false, err
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+
+	bufA := make([]byte, 64*1024)
+	bufB := make([]byte, 64*1024)
+	for {
+		n, errA := io.ReadFull(fileA, bufA)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return false, errA
+		}
+		m, errB := io.ReadFull(fileB, bufB)
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return false, errB
+		}
+		if !bytes.Equal(bufA[:n], bufB[:m]) {
+			return false, nil
+		}
+		if errA != nil {
+			// Equal chunks end both files at the same time
+			return true, nil
+		}
+	}
+}
+
+// compareForReplace compares path with target byte by byte and returns the stat of path taken
+// before the comparison, see unchangedSince.
+func compareForReplace(path, target string) (os.FileInfo, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	same, err := SameContent(path, target)
+	if err != nil {
+		return nil, err
+	}
+	if !same {
+		return nil, ErrContentDiffers
+	}
+	return info, nil
+}
+
+// unchangedSince returns ErrContentDiffers when path is no longer the file described by info, or
+// was written to since.
+func unchangedSince(path string, info os.FileInfo) error {
+	current, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(current, info) || current.Size() != info.Size() || !current.ModTime().Equal(info.ModTime()) {
+		return ErrContentDiffers
+	}
+	return nil
+}
+
+// ReplaceWithHardlink replaces path with a hard link to target once their content is compared
+// byte by byte. The link is created next to path and renamed over it, so path always exists,
+// either as the old file or as the link. A path modified while it was compared is left alone.
+func ReplaceWithHardlink(path, target string) error {
+	pathInfo, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	targetInfo, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(pathInfo, targetInfo) {
+		return nil // Already linked, renaming a link over itself would leave the temporary link
+	}
+	if pathInfo, err = compareForReplace(path, target); err != nil {
+		return err
+	}
+	tmp, err := GetNextAvailableFilePath(path + ".link")
+	if err != nil {
+		return err
+	}
+	if err := os.Link(target, tmp); err != nil {
+		if errors.Is(err, syscall.EXDEV) {
+			return ErrCrossDevice
+		}
+		return err
+	}
+	err = unchangedSince(path, pathInfo)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// ReplaceWithClone replaces path with a reflink clone of target, once their content is compared
+// byte by byte: a separate file that shares the storage of target until one of them is modified.
+// The clone keeps the permissions and modification time of path and is renamed over it, so path
+// always exists. A path modified while it was compared is left alone.
+func ReplaceWithClone(path, target string) error {
+	info, err := compareForReplace(path, target)
+	if err != nil {
+		return err
+	}
+	tmp, err := GetNextAvailableFilePath(path + ".clone")
+	if err != nil {
+		return err
+	}
+	if err := cloneFile(target, tmp); err != nil {
+		return err
+	}
+	err = os.Chmod(tmp, info.Mode().Perm())
+	if err == nil {
+		err = os.Chtimes(tmp, info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = unchangedSince(path, info)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,194 @@
+package file_helper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes content to name under dir and returns its path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// isSameFile reports whether both paths are the same file.
+func isSameFile(t *testing.T, a, b string) bool {
+	t.Helper()
+	infoA, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+func TestSameContent(t *testing.T) {
+	// Larger than the 64 KiB buffer, so the comparison takes several reads
+	large := strings.Repeat("0123456789abcdef", 10000)
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{name: "empty", a: "", b: "", want: true},
+		{name: "equal", a: "content", b: "content", want: true},
+		{name: "same size", a: "content", b: "CONTENT"},
+		{name: "prefix", a: "content", b: "content and more"},
+		{name: "large equal", a: large, b: large, want: true},
+		{name: "large, last byte differs", a: large, b: large[:len(large)-1] + "x"},
+		{name: "large, first byte differs", a: large, b: "x" + large[1:]},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a, b := writeFile(t, dir, "a", test.a), writeFile(t, dir, "b", test.b)
+			same, err := SameContent(a, b)
+			if err != nil {
+				t.Fatalf("SameContent: %v", err)
+			}
+			if same != test.want {
+				t.Errorf("SameContent = %v, want %v", same, test.want)
+			}
+		})
+	}
+
+	if _, err := SameContent(filepath.Join(t.TempDir(), "missing"), filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("SameContent of missing files succeeded")
+	}
+}
+
+func TestReplaceWithHardlink(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		setup   func(t *testing.T, path, target string) // Runs between the check of the caller and the link
+		wantErr error
+		linked  bool
+	}{
+		{name: "same content", content: "same", linked: true},
+		{name: "different content", content: "other", wantErr: ErrContentDiffers},
+		{name: "already linked", content: "same", linked: true, setup: func(t *testing.T, path, target string) {
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Link(target, path); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{name: "content changed after the check", content: "same", wantErr: ErrContentDiffers, setup: func(t *testing.T, path, target string) {
+			if err := os.WriteFile(path, []byte("edit"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			target := writeFile(t, dir, "target", "same")
+			path := writeFile(t, dir, "copy", test.content)
+			if same, err := SameContent(path, target); err != nil || same != (test.content == "same") {
+				t.Fatalf("SameContent = %v, %v", same, err)
+			}
+			if test.setup != nil {
+				test.setup(t, path, target)
+			}
+			before, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = ReplaceWithHardlink(path, target)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("ReplaceWithHardlink = %v, want %v", err, test.wantErr)
+			}
+			if linked := isSameFile(t, path, target); linked != test.linked {
+				t.Errorf("linked = %v, want %v", linked, test.linked)
+			}
+			if after, err := os.ReadFile(path); err != nil || string(after) != string(before) {
+				t.Errorf("content of path = %q, %v, want %q", after, err, before)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 2 {
+				t.Errorf("temporary files left in %s: %v", dir, entries)
+			}
+		})
+	}
+}
+
+func TestUnchangedSince(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "file", "content")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unchangedSince(path, info); err != nil {
+		t.Errorf("unchangedSince of an unchanged file = %v", err)
+	}
+
+	// Written to with the same size and the modification time moved on
+	if err := os.WriteFile(path, []byte("CONTENT"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, time.Now(), info.ModTime().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := unchangedSince(path, info); !errors.Is(err, ErrContentDiffers) {
+		t.Errorf("unchangedSince of a modified file = %v, want %v", err, ErrContentDiffers)
+	}
+
+	// Replaced by another file with the same size and modification time
+	replacement := writeFile(t, dir, "replacement", "content")
+	if err := os.Chtimes(replacement, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(replacement, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := unchangedSince(path, info); !errors.Is(err, ErrContentDiffers) {
+		t.Errorf("unchangedSince of a replaced file = %v, want %v", err, ErrContentDiffers)
+	}
+}
+
+func TestReplaceWithHardlinkCrossDevice(t *testing.T) {
+	// tmpfs is a separate device from the test temporary folder on most Linux systems
+	other, err := os.MkdirTemp("/dev/shm", "link")
+	if err != nil {
+		t.Skip("no /dev/shm:", err)
+	}
+	defer os.RemoveAll(other)
+	dir := t.TempDir()
+	target := writeFile(t, dir, "target", "same")
+	path := writeFile(t, other, "copy", "same")
+	if err := os.Link(target, filepath.Join(other, "probe")); err == nil {
+		t.Skip("/dev/shm is on the same device as", dir)
+	}
+
+	if err := ReplaceWithHardlink(path, target); !errors.Is(err, ErrCrossDevice) {
+		t.Fatalf("ReplaceWithHardlink across devices = %v, want %v", err, ErrCrossDevice)
+	}
+	if content, err := os.ReadFile(path); err != nil || string(content) != "same" {
+		t.Errorf("path after the failed link = %q, %v", content, err)
+	}
+	entries, err := os.ReadDir(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary files left in %s: %v", other, entries)
+	}
+}
@@ -0,0 +1,26 @@
+package file_helper
+
+import (
+	"os/exec"
+	"runtime"
+)
+
+// OpenWithViewer opens a file in the default application of the system, without waiting for the
+// application to be closed.
+func OpenWithViewer(path string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", path)
+	case "windows":
+		// The empty argument is the window title, start would take a quoted path for it
+		cmd = exec.Command("cmd", "/c", "start", "", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait() // Reap the launcher once it exits
+	return nil
+}
@@ -0,0 +1,364 @@
+package hash_helper
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/corona10/goimagehash"
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultCacheFileName is the name of the cache file in the folder it indexes. Every tool uses
+// the same file, so a folder hashed by one of them does not have to be hashed again by another.
+const DefaultCacheFileName = ".image_hashes.gob"
+
+// cacheFormatVersion is the version written by Save.
+// Bump it whenever the persisted layout changes and register a decoder for the
+// previous version in cacheDecoders so existing caches are migrated instead of discarded.
+const cacheFormatVersion = 4
+
+// Since version 4 the cache is keyed by CacheKey instead of the path as the folder walk saw it.
+const firstPortableCacheVersion = 4
+
+// ErrNewerCacheVersion is returned when the cache was written by a newer build of the tools.
+var ErrNewerCacheVersion = errors.New("cache file was written by a newer version of this tool")
+
+// StatusOut receives the progress messages of the package.
+var StatusOut io.Writer = os.Stdout
+
+// cacheHeader is encoded ahead of the cache body so the loader knows how to decode the rest.
+type cacheHeader struct {
+	Version int
+}
+
+// Entry is the persisted hashes of a single image together with the state of the file they
+// were computed from, so the entry can be invalidated when the file changes.
+// An entry keeps one hash per algorithm and size that was ever requested for the file.
+type Entry struct {
+	Hashes       []StoredHash
+	Width        int              // Image width in pixels, 0 in entries hashed before it was stored
+	Height       int              // Image height in pixels
+	Regions      []RegionHash     // Hashes of crop regions, only computed for crop detection
+	RegionLayout int              // Layout the regions were computed with, set even when no region fits the image
+	RegionKind   goimagehash.Kind // Algorithm of the region hashes
+	RegionBits   int              // Size in bits of the region hashes
+	Color        []float32        // Colour histogram, only computed for colour comparison
+	ColorLayout  int              // Layout the histogram was computed with
+	// Keyframes of a video, only sampled for video search. Video entries hold no other hashes.
+	Frames        []FrameHash
+	FrameInterval time.Duration // Minimum interval the keyframes were sampled with
+	FrameLayout   int           // Layout the keyframes were sampled with
+	Size          int64         // File size in bytes when hashed
+	ModTime       int64         // File modification time (Unix nanoseconds) when hashed
+}
+
+// RegionHash is the hash of a rectangular region of an image. The rectangle is stored in
+// fractions of the image width and height so it does not depend on the working size.
+type RegionHash struct {
+	X, Y, W, H float32
+	Hash       StoredHash
+}
+
+// Overlap returns the share of the image area covered by the region.
+func (r RegionHash) Overlap() float64 {
+	return float64(r.W) * float64(r.H)
+}
+
+// FrameHash is the signature of one sampled keyframe of a video.
+type FrameHash struct {
+	Timestamp time.Duration
+	Hashes    []StoredHash
+	Color     []float32 // Colour histogram, only computed for colour comparison
+}
+
+// Entries stores the mapping from file path to its cached hash entry.
+type Entries map[string]*Entry
+
+// Cache is the persisted cache content that follows the header. On disk, entries and index
+// nodes are keyed by CacheKey; in memory they are keyed by the file path, see resolvePaths.
+type Cache struct {
+	Entries    Entries
+	Index      *BKTree // Similarity index over Entries, nil until it has been built
+	CropIndex  *BKTree // Index over the regional hashes of Entries, only built for crop detection
+	FrameIndex *BKTree // Index over the keyframes of the videos in Entries, only built for video search
+}
+
+// NewEntry returns an empty entry for a file with the given stat info.
+func NewEntry(info os.FileInfo) *Entry {
+	return &Entry{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+}
+
+// AddHashes stores the hashes in the entry, replacing any hash of the same kind and size.
+func (e *Entry) AddHashes(hashes []*goimagehash.ExtImageHash) {
+	for _, hash := range hashes {
+		stored := StoredHash{Hash: hash.GetHash(), Kind: hash.GetKind(), Bits: hash.Bits()}
+		replaced := false
+		for i := range e.Hashes {
+			if e.Hashes[i].Kind == stored.Kind && e.Hashes[i].Bits == stored.Bits {
+				e.Hashes[i] = stored
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			e.Hashes = append(e.Hashes, stored)
+		}
+	}
+}
+
+// Hash returns the stored hash of the given kind and size, or nil when the entry has none.
+func (e *Entry) Hash(kind goimagehash.Kind, bits int) *goimagehash.ExtImageHash {
+	for _, stored := range e.Hashes {
+		if stored.Kind == kind && stored.Bits == bits {
+			return goimagehash.NewExtImageHash(stored.Hash, stored.Kind, stored.Bits)
+		}
+	}
+	return nil
+}
+
+// IsFresh reports whether the entry was computed from the file as it is described by info.
+func (e *Entry) IsFresh(info os.FileInfo) bool {
+	return e.Size == info.Size() && e.ModTime == info.ModTime().UnixNano()
+}
+
+// PruneMissing drops the entries of files that no longer exist and returns how many were
+// dropped. Candidates were just found by the folder walk, so only the other entries are checked.
+func PruneMissing(entries Entries, candidatePaths []string) int {
+	candidates := make(map[string]bool, len(candidatePaths))
+	for _, path := range candidatePaths {
+		candidates[path] = true
+	}
+
+	pruned := 0
+	for path := range entries {
+		if candidates[path] {
+			continue
+		}
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			delete(entries, path)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// cacheEntryV1 is the version 1 entry layout, which held a single hash per image.
+type cacheEntryV1 struct {
+	Hash    []uint64
+	Kind    goimagehash.Kind
+	Bits    int
+	Size    int64
+	ModTime int64
+}
+
+// cacheDecoders decodes the cache body for each known format version into the current
+// in-memory representation. Older versions convert (migrate) their entries while decoding.
+var cacheDecoders = map[int]func(decoder *gob.Decoder) (*Cache, error){
+	1: func(decoder *gob.Decoder) (*Cache, error) {
+		var entriesV1 map[string]*cacheEntryV1
+		if err := decoder.Decode(&entriesV1); err != nil {
+			return nil, err
+		}
+		hashes := make(Entries, len(entriesV1))
+		for path, entryV1 := range entriesV1 {
+			hashes[path] = &Entry{
+				Hashes:  []StoredHash{{Hash: entryV1.Hash, Kind: entryV1.Kind, Bits: entryV1.Bits}},
+				Size:    entryV1.Size,
+				ModTime: entryV1.ModTime,
+			}
+		}
+		return &Cache{Entries: hashes}, nil
+	},
+	2: func(decoder *gob.Decoder) (*Cache, error) {
+		hashes := make(Entries)
+		err := decoder.Decode(&hashes)
+		return &Cache{Entries: hashes}, err
+	},
+	3: decodeCache,
+	4: decodeCache,
+}
+
+// decodeCache decodes a body stored as a Cache, the layout of version 3 onwards.
+func decodeCache(decoder *gob.Decoder) (*Cache, error) {
+	var body Cache
+	err := decoder.Decode(&body)
+	return &body, err
+}
+
+// CacheKey returns the key a file is stored under: its path relative to the cached folder with
+// forward slashes and NFC normalised Unicode, so the cache survives the folder being mounted at
+// another path or moved between systems that normalise file names differently (macOS uses NFD).
+// Files outside the folder keep their absolute path.
+func CacheKey(root string, path string) string {
+	key, err := filepath.Rel(root, path)
+	if err != nil || key == ".." || strings.HasPrefix(key, ".."+string(filepath.Separator)) {
+		if key, err = filepath.Abs(path); err != nil {
+			key = path
+		}
+	}
+	return norm.NFC.String(filepath.ToSlash(key))
+}
+
+// keyPath returns the path of a cache key under root, the inverse of CacheKey for files whose
+// name is already NFC on disk. resolvePaths prefers the actual path found by the folder walk.
+func keyPath(root string, key string) string {
+	path := filepath.FromSlash(key)
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
+
+// resolvePaths rekeys a cache loaded from disk by file path. Keys are matched against the
+// candidate paths of the folder walk first, the remaining entries (deleted or unlisted files)
+// are joined with root.
+func (c *Cache) resolvePaths(root string, candidatePaths []string) {
+	paths := make(map[string]string, len(candidatePaths))
+	for _, path := range candidatePaths {
+		paths[CacheKey(root, path)] = path
+	}
+	pathOf := func(key string) string {
+		if path, found := paths[key]; found {
+			return path
+		}
+		return keyPath(root, key)
+	}
+
+	entries := make(Entries, len(c.Entries))
+	for key, entry := range c.Entries {
+		entries[pathOf(key)] = entry
+	}
+	c.Entries = entries
+	c.Index = c.Index.WithPaths(pathOf)
+	c.CropIndex = c.CropIndex.WithPaths(pathOf)
+	c.FrameIndex = c.FrameIndex.WithPaths(pathOf)
+}
+
+// keyed returns a copy of the cache keyed by CacheKey, the form that is written to disk.
+func (c *Cache) keyed(root string) *Cache {
+	keyOf := func(path string) string { return CacheKey(root, path) }
+	entries := make(Entries, len(c.Entries))
+	for path, entry := range c.Entries {
+		entries[keyOf(path)] = entry
+	}
+	return &Cache{Entries: entries, Index: c.Index.WithPaths(keyOf), CropIndex: c.CropIndex.WithPaths(keyOf),
+		FrameIndex: c.FrameIndex.WithPaths(keyOf)}
+}
+
+// Clone returns a copy of the cache that can be updated without affecting the original.
+// Hashes are copied because AddHashes replaces them in place, the other slices are only
+// ever replaced as a whole. The indexes are shared, they are replaced when rebuilt.
+func (c *Cache) Clone() *Cache {
+	entries := make(Entries, len(c.Entries))
+	for path, entry := range c.Entries {
+		copied := *entry
+		copied.Hashes = slices.Clone(entry.Hashes)
+		entries[path] = &copied
+	}
+	return &Cache{Entries: entries, Index: c.Index, CropIndex: c.CropIndex, FrameIndex: c.FrameIndex}
+}
+
+// NewCache returns an empty cache.
+func NewCache() *Cache {
+	return &Cache{Entries: make(Entries)}
+}
+
+// Load loads image hashes and the persisted indexes from a versioned gob cache file.
+// The returned cache is keyed by the file paths of root, see resolvePaths.
+func Load(cacheFile string, root string, candidatePaths []string) (*Cache, error) {
+	body, err := ReadCacheFile(cacheFile, root)
+	if err != nil {
+		return nil, err
+	}
+	body.resolvePaths(root, candidatePaths)
+	return body, nil
+}
+
+// ReadCacheFile decodes a cache file into a cache keyed by CacheKey, migrating older versions.
+func ReadCacheFile(cacheFile string, root string) (*Cache, error) {
+	if _, err := os.Stat(cacheFile); os.IsNotExist(err) {
+		fmt.Fprintf(StatusOut, "Cache file %s not found, starting fresh.\n", cacheFile)
+		return NewCache(), nil // No cache file is not an error
+	}
+
+	fmt.Fprintf(StatusOut, "Loading image hashes from cache: %s\n", cacheFile)
+	file, err := os.Open(cacheFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache file %s: %w", cacheFile, err)
+	}
+	defer file.Close()
+
+	decoder := gob.NewDecoder(file)
+	var header cacheHeader
+	if err := decoder.Decode(&header); err != nil {
+		// Unversioned caches from older builds could never be written successfully, so there is nothing to migrate
+		fmt.Fprintf(os.Stderr, "Warning: Could not read cache header from %s (maybe corrupted or unversioned?), starting fresh: %v\n", cacheFile, err)
+		return NewCache(), nil
+	}
+	if header.Version > cacheFormatVersion {
+		return nil, fmt.Errorf("%w (cache version %d, supported up to %d)", ErrNewerCacheVersion, header.Version, cacheFormatVersion)
+	}
+	decode, ok := cacheDecoders[header.Version]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Warning: Unknown cache version %d in %s, starting fresh.\n", header.Version, cacheFile)
+		return NewCache(), nil
+	}
+
+	body, err := decode(decoder)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Could not decode cache file %s (maybe corrupted?), starting fresh: %v\n", cacheFile, err)
+		return NewCache(), nil // Return empty cache instead of error
+	}
+	if body.Entries == nil {
+		body.Entries = make(Entries)
+	}
+	if header.Version < firstPortableCacheVersion {
+		// Older caches are keyed by the walked path, the index is rebuilt on the new keys
+		entries := make(Entries, len(body.Entries))
+		for path, entry := range body.Entries {
+			entries[CacheKey(root, path)] = entry
+		}
+		body = &Cache{Entries: entries}
+	}
+	if header.Version != cacheFormatVersion {
+		fmt.Fprintf(StatusOut, "Migrated cache from version %d to version %d.\n", header.Version, cacheFormatVersion)
+	}
+
+	return body, nil
+}
+
+// Save saves image hashes and the indexes to a cache file using gob encoding, keyed relative to
+// root. The file is written to a temporary path first so an interrupted run never leaves a
+// truncated cache.
+func Save(cacheFile string, root string, cache *Cache) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(cacheFile), filepath.Base(cacheFile)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create cache file %s: %w", cacheFile, err)
+	}
+	defer os.Remove(tmpFile.Name()) // No-op once the rename succeeded
+
+	encoder := gob.NewEncoder(tmpFile)
+	if err := encoder.Encode(cacheHeader{Version: cacheFormatVersion}); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to encode cache header to %s: %w", cacheFile, err)
+	}
+	if err := encoder.Encode(cache.keyed(root)); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to encode hashes to cache file %s: %w", cacheFile, err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to write cache file %s: %w", cacheFile, err)
+	}
+	if err := os.Rename(tmpFile.Name(), cacheFile); err != nil {
+		return fmt.Errorf("failed to replace cache file %s: %w", cacheFile, err)
+	}
+	fmt.Fprintf(StatusOut, "Saved %d image hashes to cache: %s\n", len(cache.Entries), cacheFile)
+	return nil
+}
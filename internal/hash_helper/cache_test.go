@@ -0,0 +1,204 @@
+package hash_helper
+
+import (
+	"encoding/gob"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/corona10/goimagehash"
+)
+
+// writeCacheFile writes a cache file of the given version with the given body.
+func writeCacheFile(t *testing.T, path string, version int, body any) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	encoder := gob.NewEncoder(file)
+	if err := encoder.Encode(cacheHeader{Version: version}); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Encode(body); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadOldCacheVersions(t *testing.T) {
+	StatusOut = io.Discard
+	root := t.TempDir()
+	path := filepath.Join(root, "sub", "image.jpg")
+	hash := StoredHash{Hash: []uint64{0xf0f0}, Kind: goimagehash.PHash, Bits: 64}
+	index := &BKTree{Spec: "perception/64", Nodes: []Node{{Path: path, Hash: hash.Hash}}}
+
+	tests := []struct {
+		name      string
+		version   int
+		body      any
+		wantIndex bool
+	}{
+		{
+			name:    "version 1 single hash",
+			version: 1,
+			body:    map[string]*cacheEntryV1{path: {Hash: hash.Hash, Kind: hash.Kind, Bits: hash.Bits, Size: 10, ModTime: 20}},
+		},
+		{
+			name:    "version 2 entries",
+			version: 2,
+			body:    Entries{path: {Hashes: []StoredHash{hash}, Size: 10, ModTime: 20}},
+		},
+		{
+			name:    "version 3 keyed by path, index dropped",
+			version: 3,
+			body:    Cache{Entries: Entries{path: {Hashes: []StoredHash{hash}, Size: 10, ModTime: 20}}, Index: index},
+		},
+		{
+			name:      "version 4 keyed by cache key",
+			version:   4,
+			body:      Cache{Entries: Entries{"sub/image.jpg": {Hashes: []StoredHash{hash}, Size: 10, ModTime: 20}}, Index: index.WithPaths(func(string) string { return "sub/image.jpg" })},
+			wantIndex: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cacheFile := filepath.Join(root, DefaultCacheFileName)
+			writeCacheFile(t, cacheFile, test.version, test.body)
+			cache, err := Load(cacheFile, root, []string{path})
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			entry := cache.Entries[path]
+			if len(cache.Entries) != 1 || entry == nil {
+				t.Fatalf("Load returned entries %v, want one for %s", cache.Entries, path)
+			}
+			if got := entry.Hash(hash.Kind, hash.Bits); got == nil || got.GetHash()[0] != hash.Hash[0] {
+				t.Errorf("hash = %v, want %v", got, hash.Hash)
+			}
+			if entry.Size != 10 || entry.ModTime != 20 {
+				t.Errorf("size and time = %d, %d, want 10, 20", entry.Size, entry.ModTime)
+			}
+			if valid := cache.Index.IsValidFor(index.Spec, NodeFingerprint(path, 0, hash.Hash)); valid != test.wantIndex {
+				t.Errorf("index valid = %v, want %v", valid, test.wantIndex)
+			}
+		})
+	}
+}
+
+func TestLoadNewerCacheVersion(t *testing.T) {
+	StatusOut = io.Discard
+	cacheFile := filepath.Join(t.TempDir(), DefaultCacheFileName)
+	writeCacheFile(t, cacheFile, cacheFormatVersion+1, Cache{})
+	if _, err := Load(cacheFile, filepath.Dir(cacheFile), nil); err == nil {
+		t.Error("Load accepted a cache of a newer version")
+	}
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	StatusOut = io.Discard
+	root := t.TempDir()
+	path := filepath.Join(root, "image.jpg")
+	hash := []uint64{42}
+	cache := &Cache{
+		Entries: Entries{path: {Hashes: []StoredHash{{Hash: hash, Kind: goimagehash.AHash, Bits: 64}}, Size: 1}},
+		Index:   &BKTree{Spec: "average/64", Fingerprint: NodeFingerprint(path, 0, hash), Nodes: []Node{{Path: path, Hash: hash}}},
+	}
+	cacheFile := filepath.Join(root, DefaultCacheFileName)
+	if err := Save(cacheFile, root, cache); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := Load(cacheFile, root, []string{path})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if loaded.Entries[path] == nil {
+		t.Errorf("Load lost the entry of %s", path)
+	}
+	if !loaded.Index.IsValidFor("average/64", cache.Index.Fingerprint) {
+		t.Error("the saved index is not valid after loading")
+	}
+}
+
+func TestEntryIsFresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.jpg")
+	if err := os.WriteFile(path, []byte("image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := NewEntry(info)
+
+	tests := []struct {
+		name   string
+		change func() error
+		fresh  bool
+	}{
+		{name: "unchanged", change: func() error { return nil }, fresh: true},
+		{name: "modification time", change: func() error {
+			return os.Chtimes(path, time.Now(), info.ModTime().Add(time.Second))
+		}},
+		{name: "size", change: func() error {
+			if err := os.WriteFile(path, []byte("larger image"), 0o644); err != nil {
+				return err
+			}
+			// Same modification time, only the size tells the change
+			return os.Chtimes(path, time.Now(), info.ModTime())
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.change(); err != nil {
+				t.Fatal(err)
+			}
+			changed, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fresh := entry.IsFresh(changed); fresh != test.fresh {
+				t.Errorf("IsFresh = %v, want %v", fresh, test.fresh)
+			}
+		})
+	}
+}
+
+func TestUpdateRehashesChangedFiles(t *testing.T) {
+	StatusOut = io.Discard
+	root := t.TempDir()
+	unchanged, changed := filepath.Join(root, "a.jpg"), filepath.Join(root, "b.jpg")
+	for _, path := range []string{unchanged, changed} {
+		if err := os.WriteFile(path, []byte("image"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	calls := make(map[string]int)
+	hash := func(path string) (func(*Entry), error) {
+		calls[path]++
+		return func(entry *Entry) {
+			entry.AddHashes([]*goimagehash.ExtImageHash{goimagehash.NewExtImageHash([]uint64{1}, goimagehash.PHash, 64)})
+		}, nil
+	}
+	has := func(entry *Entry) bool { return entry.Hash(goimagehash.PHash, 64) != nil }
+	entries := make(Entries)
+	Update(entries, []string{unchanged, changed}, 1, has, hash)
+	if err := os.WriteFile(changed, []byte("edited image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	Update(entries, []string{unchanged, changed}, 1, has, hash)
+
+	if calls[unchanged] != 1 || calls[changed] != 2 {
+		t.Errorf("hashed unchanged %d and changed %d times, want 1 and 2", calls[unchanged], calls[changed])
+	}
+	info, err := os.Stat(changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entries[changed].IsFresh(info) {
+		t.Error("the entry of the changed file is stale")
+	}
+}
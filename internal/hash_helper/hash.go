@@ -0,0 +1,38 @@
+package hash_helper
+
+import (
+	"image"
+
+	"github.com/corona10/goimagehash"
+)
+
+// Algorithm is one of the goimagehash algorithms the tools can compute.
+type Algorithm struct {
+	Name    string
+	Kind    goimagehash.Kind
+	Compute func(img image.Image, width, height int) (*goimagehash.ExtImageHash, error)
+}
+
+// Algorithms lists the supported algorithms by their -algo name.
+var Algorithms = map[string]Algorithm{
+	"average":    {Name: "average", Kind: goimagehash.AHash, Compute: goimagehash.ExtAverageHash},
+	"difference": {Name: "difference", Kind: goimagehash.DHash, Compute: goimagehash.ExtDifferenceHash},
+	"perception": {Name: "perception", Kind: goimagehash.PHash, Compute: goimagehash.ExtPerceptionHash},
+}
+
+// KindName returns the -algo name of a hash kind.
+func KindName(kind goimagehash.Kind) (string, bool) {
+	for name, algorithm := range Algorithms {
+		if algorithm.Kind == kind {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// StoredHash is the persisted form of a goimagehash.ExtImageHash, whose fields are unexported.
+type StoredHash struct {
+	Hash []uint64         // Raw hash bits as returned by ExtImageHash.GetHash
+	Kind goimagehash.Kind // Hash algorithm (pHash, aHash, ...)
+	Bits int              // Hash size in bits
+}
@@ -0,0 +1,141 @@
+package hash_helper
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/bits"
+)
+
+// BKTree is a Burkhard-Keller tree over one hash of every indexed image. Children are keyed by
+// their Hamming distance to the parent, so by the triangle inequality a query only has to
+// descend into children whose edge distance is within the threshold of its own distance.
+// The tree is persisted in the cache file and rebuilt when the indexed hashes change.
+type BKTree struct {
+	Spec        string // Algorithm and size of the indexed hash, chosen by the tool that built it
+	Fingerprint uint64 // Fingerprint of the indexed paths and hashes, see NodeFingerprint
+	Nodes       []Node
+}
+
+// Node is one indexed image (or image region for the crop index, video keyframe for the frame
+// index), node 0 is the root.
+type Node struct {
+	Path     string
+	Region   int32 // Index into Entry.Regions in the crop index or Entry.Frames in the frame index
+	Hash     []uint64
+	Children []Edge
+}
+
+// Edge links a node to a child at the given Hamming distance.
+type Edge struct {
+	Distance int32
+	Node     int32
+}
+
+// Result is a node found within the query distance.
+type Result struct {
+	Path     string
+	Region   int32
+	Distance int
+}
+
+// HammingDistance counts the differing bits of two raw hashes of the same size.
+func HammingDistance(a, b []uint64) int {
+	distance := 0
+	for i := range a {
+		distance += bits.OnesCount64(a[i] ^ b[i])
+	}
+	return distance
+}
+
+// NodeFingerprint hashes the identity and content of one index node. A tree fingerprint is the
+// XOR of the fingerprints of its nodes, which keeps it independent of the walk order.
+func NodeFingerprint(path string, region int32, hash []uint64) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(path))
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint32(buf, uint32(region))
+	hasher.Write(buf[:4])
+	for _, word := range hash {
+		binary.LittleEndian.PutUint64(buf, word)
+		hasher.Write(buf)
+	}
+	return hasher.Sum64()
+}
+
+// WithPaths returns a copy of the tree with every node path mapped by pathOf. The fingerprint is
+// recomputed from the nodes, so a tree converted between cache keys and file paths stays valid
+// exactly when its nodes still describe the candidates.
+func (t *BKTree) WithPaths(pathOf func(string) string) *BKTree {
+	if t == nil {
+		return nil
+	}
+	tree := &BKTree{Spec: t.Spec, Nodes: make([]Node, len(t.Nodes))}
+	for i, node := range t.Nodes {
+		node.Path = pathOf(node.Path)
+		tree.Nodes[i] = node
+		tree.Fingerprint ^= NodeFingerprint(node.Path, node.Region, node.Hash)
+	}
+	return tree
+}
+
+// IsValidFor reports whether a (possibly persisted) index was built on the hash identified by
+// spec and still describes the nodes with the given fingerprint.
+func (t *BKTree) IsValidFor(spec string, fingerprint uint64) bool {
+	return t != nil && t.Spec == spec && t.Fingerprint == fingerprint
+}
+
+// Insert adds a node to the tree, its children are ignored.
+func (t *BKTree) Insert(node Node) {
+	hash := node.Hash
+	node.Children = nil
+	t.Nodes = append(t.Nodes, node)
+	newNode := int32(len(t.Nodes) - 1)
+	if newNode == 0 {
+		return
+	}
+
+	current := int32(0)
+	for {
+		distance := int32(HammingDistance(t.Nodes[current].Hash, hash))
+		next := int32(-1)
+		for _, edge := range t.Nodes[current].Children {
+			if edge.Distance == distance {
+				next = edge.Node
+				break
+			}
+		}
+		if next < 0 {
+			t.Nodes[current].Children = append(t.Nodes[current].Children, Edge{Distance: distance, Node: newNode})
+			return
+		}
+		current = next
+	}
+}
+
+// Query returns every indexed hash within maxDistance of hash and the number of nodes visited.
+func (t *BKTree) Query(hash []uint64, maxDistance int) ([]Result, int) {
+	if t == nil || len(t.Nodes) == 0 {
+		return nil, 0
+	}
+
+	var results []Result
+	visited := 0
+	stack := []int32{0}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		visited++
+
+		node := &t.Nodes[current]
+		distance := HammingDistance(node.Hash, hash)
+		if distance <= maxDistance {
+			results = append(results, Result{Path: node.Path, Region: node.Region, Distance: distance})
+		}
+		for _, edge := range node.Children {
+			if int(edge.Distance) >= distance-maxDistance && int(edge.Distance) <= distance+maxDistance {
+				stack = append(stack, edge.Node)
+			}
+		}
+	}
+	return results, visited
+}
@@ -0,0 +1,107 @@
+package hash_helper
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestBKTreeQueryMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name  string
+		nodes int
+		words int
+	}{
+		{name: "empty", nodes: 0, words: 1},
+		{name: "single", nodes: 1, words: 1},
+		{name: "64 bit hashes", nodes: 500, words: 1},
+		{name: "256 bit hashes", nodes: 300, words: 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tree := &BKTree{}
+			var nodes []Node
+			hashes := make(map[string][]uint64)
+			randomHash := func() []uint64 {
+				hash := make([]uint64, test.words)
+				for i := range hash {
+					// Few set bits so the distances spread over small values too
+					hash[i] = rng.Uint64() & rng.Uint64() & rng.Uint64()
+				}
+				return hash
+			}
+			for i := range test.nodes {
+				node := Node{Path: fmt.Sprintf("image%d.jpg", i), Hash: randomHash()}
+				if i%10 == 0 && i > 0 {
+					node.Hash = slices.Clone(nodes[i-1].Hash) // Duplicates share a distance 0 edge
+				}
+				nodes = append(nodes, node)
+				hashes[node.Path] = node.Hash
+				tree.Insert(node)
+			}
+
+			for _, maxDistance := range []int{0, 3, 10, 20, 64 * test.words} {
+				for range 20 {
+					query := randomHash()
+					if len(nodes) > 0 && rng.Intn(2) == 0 {
+						query = nodes[rng.Intn(len(nodes))].Hash
+					}
+					var want []string
+					for _, node := range nodes {
+						if HammingDistance(node.Hash, query) <= maxDistance {
+							want = append(want, node.Path)
+						}
+					}
+					results, visited := tree.Query(query, maxDistance)
+					var got []string
+					for _, result := range results {
+						if result.Distance != HammingDistance(query, hashes[result.Path]) {
+							t.Errorf("%s reported at distance %d", result.Path, result.Distance)
+						}
+						got = append(got, result.Path)
+					}
+					slices.Sort(want)
+					slices.Sort(got)
+					if !slices.Equal(got, want) {
+						t.Fatalf("Query(%x, %d) = %v, want %v", query, maxDistance, got, want)
+					}
+					if visited > len(nodes) {
+						t.Errorf("Query visited %d of %d nodes", visited, len(nodes))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBKTreeIsValidFor(t *testing.T) {
+	hash := []uint64{7}
+	fingerprint := NodeFingerprint("a.jpg", 0, hash)
+	tree := &BKTree{Spec: "perception/64", Fingerprint: fingerprint, Nodes: []Node{{Path: "a.jpg", Hash: hash}}}
+
+	tests := []struct {
+		name        string
+		tree        *BKTree
+		spec        string
+		fingerprint uint64
+		want        bool
+	}{
+		{name: "same nodes", tree: tree, spec: "perception/64", fingerprint: fingerprint, want: true},
+		{name: "missing index", tree: nil, spec: "perception/64", fingerprint: fingerprint},
+		{name: "other algorithm", tree: tree, spec: "average/64", fingerprint: fingerprint},
+		{name: "changed hash", tree: tree, spec: "perception/64", fingerprint: NodeFingerprint("a.jpg", 0, []uint64{8})},
+		{name: "renamed file", tree: tree, spec: "perception/64", fingerprint: NodeFingerprint("b.jpg", 0, hash)},
+		{name: "added file", tree: tree, spec: "perception/64", fingerprint: fingerprint ^ NodeFingerprint("b.jpg", 0, hash)},
+		{name: "paths mapped", tree: tree.WithPaths(func(string) string { return "b.jpg" }), spec: "perception/64",
+			fingerprint: NodeFingerprint("b.jpg", 0, hash), want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.tree.IsValidFor(test.spec, test.fingerprint); got != test.want {
+				t.Errorf("IsValidFor = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package hash_helper
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// HashFunc decodes and hashes one file. It returns a function that stores the result in the
+// entry of the file, or nil when the file cannot be hashed any more.
+type HashFunc func(path string) (store func(entry *Entry), err error)
+
+// hashJob is a file to hash with its stat, which is stored with the hashes.
+type hashJob struct {
+	Path string
+	Info os.FileInfo
+}
+
+// hashResult is the outcome of a hashJob.
+type hashResult struct {
+	hashJob
+	Store func(entry *Entry)
+	Err   error
+}
+
+// pendingJobs returns a job for every path that has no entry, whose entry is stale because the
+// file changed size or modification time since it was hashed, or whose entry lacks something
+// has asks for.
+func pendingJobs(entries Entries, paths []string, has func(entry *Entry) bool) []hashJob {
+	jobs := make([]hashJob, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Could not stat %s: %v\n", path, err)
+			delete(entries, path)
+			continue
+		}
+		if entry, exists := entries[path]; exists && entry.IsFresh(info) && has(entry) {
+			continue
+		}
+		jobs = append(jobs, hashJob{Path: path, Info: info})
+	}
+	return jobs
+}
+
+// Update makes sure entries hold a fresh entry with everything has asks for for every path.
+// New or changed files are hashed by a pool of workers; the channels are unbuffered so at most
+// workers files are decoded at a time. Hashes of other algorithms are kept when the existing
+// entry still describes the same file, entries of files that cannot be hashed are dropped.
+func Update(entries Entries, paths []string, workers int, has func(entry *Entry) bool, hash HashFunc) {
+	workers = max(workers, 1)
+	pending := pendingJobs(entries, paths, has)
+	if len(pending) == 0 {
+		fmt.Fprintln(StatusOut, "All image hashes found in cache. No new calculations needed.")
+		return
+	}
+	fmt.Fprintf(StatusOut, "Calculating hashes for %d new images (%d workers)...\n", len(pending), workers)
+
+	jobs := make(chan hashJob)
+	results := make(chan hashResult)
+	go func() {
+		defer close(jobs)
+		for _, job := range pending {
+			jobs <- job
+		}
+	}()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				store, err := hash(job.Path)
+				results <- hashResult{hashJob: job, Store: store, Err: err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Entries are only touched here, so the map needs no lock
+	bar := progressbar.Default(int64(len(pending)), "Hashing Images")
+	for result := range results {
+		if result.Err != nil {
+			fmt.Fprintf(os.Stderr, "\nWarning: Error processing %s: %v\n", result.Path, result.Err)
+		}
+		if result.Store == nil {
+			// The file could not be hashed any more, drop its stale entry
+			delete(entries, result.Path)
+		} else {
+			entry := entries[result.Path]
+			if entry == nil || !entry.IsFresh(result.Info) {
+				entry = NewEntry(result.Info)
+				entries[result.Path] = entry
+			}
+			result.Store(entry)
+		}
+		bar.Add(1)
+	}
+}
@@ -0,0 +1,80 @@
+package hash_helper
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/corona10/goimagehash"
+)
+
+// writeTree creates width folders per level, depth levels deep, with files images in each
+// folder. Files only need an image extension to be found by FindImages.
+func writeTree(t *testing.T, root string, depth, width, files int) {
+	t.Helper()
+	for i := range files {
+		if err := os.WriteFile(filepath.Join(root, fmt.Sprintf("img%d.jpg", i)), []byte(root), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if depth == 0 {
+		return
+	}
+	for i := range width {
+		dir := filepath.Join(root, fmt.Sprintf("dir%d", i))
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeTree(t, dir, depth-1, width, files)
+	}
+}
+
+func TestUpdateHashesEveryImageOnce(t *testing.T) {
+	StatusOut = io.Discard
+	root := t.TempDir()
+	writeTree(t, root, 3, 4, 3) // 85 folders, 255 images
+	paths, err := FindImages(root, nil)
+	if err != nil {
+		t.Fatalf("FindImages: %v", err)
+	}
+	if len(paths) != 255 {
+		t.Fatalf("FindImages found %d images, want 255", len(paths))
+	}
+
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	hash := func(path string) (func(*Entry), error) {
+		mu.Lock()
+		calls[path]++
+		mu.Unlock()
+		return func(entry *Entry) {
+			entry.AddHashes([]*goimagehash.ExtImageHash{goimagehash.NewExtImageHash([]uint64{uint64(len(path))}, goimagehash.PHash, 64)})
+		}, nil
+	}
+	has := func(entry *Entry) bool { return entry.Hash(goimagehash.PHash, 64) != nil }
+
+	entries := make(Entries)
+	Update(entries, paths, 8, has, hash)
+	for _, path := range paths {
+		if calls[path] != 1 {
+			t.Errorf("%s hashed %d times, want 1", path, calls[path])
+		}
+		if entry := entries[path]; entry == nil || !has(entry) {
+			t.Errorf("%s has no hash", path)
+		}
+	}
+	if len(calls) != len(paths) || len(entries) != len(paths) {
+		t.Errorf("hashed %d files into %d entries, want %d", len(calls), len(entries), len(paths))
+	}
+
+	// Nothing changed, the second run hashes nothing
+	Update(entries, paths, 8, has, hash)
+	for _, path := range paths {
+		if calls[path] != 1 {
+			t.Errorf("%s hashed again by the second run", path)
+		}
+	}
+}
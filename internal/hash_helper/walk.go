@@ -0,0 +1,56 @@
+package hash_helper
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/mattanapol/image_manager/internal/image_helper"
+)
+
+// Walk calls fn for every regular file under root. Paths skip returns true for are ignored, a
+// skipped folder is not descended into. Entries that cannot be read are reported and skipped,
+// only an unreadable root is an error.
+func Walk(root string, skip func(path string) bool, fn func(path string, d fs.DirEntry)) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Warning: Error accessing path %q: %v\n", path, err)
+			return nil
+		}
+		if skip != nil && skip(path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			fn(path, d)
+		}
+		return nil
+	})
+}
+
+// IsImageFile checks if a file is a supported image. Files with a known image extension are
+// accepted as is (decoding sniffs their content anyway), the others are sniffed so images with a
+// missing or unusual extension are found too.
+func IsImageFile(path string) bool {
+	return image_helper.HasImageExtension(path) || image_helper.IsImage(path)
+}
+
+// FindImages recursively finds all potential image files under root, see Walk and IsImageFile.
+func FindImages(root string, skip func(path string) bool) ([]string, error) {
+	var imageFiles []string
+	err := Walk(root, skip, func(path string, d fs.DirEntry) {
+		if IsImageFile(path) {
+			imageFiles = append(imageFiles, path)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking the path %q: %w", root, err)
+	}
+	return imageFiles, nil
+}
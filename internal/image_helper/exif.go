@@ -0,0 +1,154 @@
+package image_helper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// EXIF tags holding a date, in order of preference
+const (
+	tagExifIFD           = 0x8769
+	tagDateTime          = 0x0132
+	tagDateTimeOriginal  = 0x9003
+	tagDateTimeDigitized = 0x9004
+)
+
+// exifDateLayout is the layout of the EXIF date tags, in the local time of the camera.
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// ErrNoDate is returned by DateTaken when the file has no EXIF date.
+var ErrNoDate = errors.New("no EXIF date")
+
+// DateTaken returns the date the photo was taken from the EXIF metadata of a JPEG or TIFF file:
+// DateTimeOriginal, then DateTimeDigitized and finally DateTime.
+func DateTaken(path string) (time.Time, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(file, head); err != nil {
+		return time.Time{}, ErrNoDate
+	}
+	switch {
+	case head[0] == 0xFF && head[1] == 0xD8:
+		base, err := findJPEGExif(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return readTIFFDate(file, base)
+	case bytes.Equal(head, []byte("II*\x00")) || bytes.Equal(head, []byte("MM\x00*")):
+		return readTIFFDate(file, 0)
+	}
+	return time.Time{}, ErrNoDate
+}
+
+// findJPEGExif walks the JPEG segments up to the image data and returns the offset of the TIFF
+// header inside the APP1 Exif segment.
+func findJPEGExif(file io.ReadSeeker) (int64, error) {
+	offset := int64(2)
+	marker := make([]byte, 4)
+	for {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if _, err := io.ReadFull(file, marker); err != nil || marker[0] != 0xFF {
+			return 0, ErrNoDate
+		}
+		length := int64(binary.BigEndian.Uint16(marker[2:]))
+		switch marker[1] {
+		case 0xDA, 0xD9: // Start of scan or end of image, the metadata comes before
+			return 0, ErrNoDate
+		case 0xE1:
+			id := make([]byte, 6)
+			if _, err := io.ReadFull(file, id); err == nil && bytes.Equal(id, []byte("Exif\x00\x00")) {
+				return offset + 4 + 6, nil
+			}
+		}
+		offset += 2 + length
+	}
+}
+
+// readTIFFDate reads the date tags of the TIFF structure starting at base.
+func readTIFFDate(file io.ReaderAt, base int64) (time.Time, error) {
+	header := make([]byte, 8)
+	if _, err := file.ReadAt(header, base); err != nil {
+		return time.Time{}, ErrNoDate
+	}
+	var order binary.ByteOrder
+	switch string(header[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, ErrNoDate
+	}
+	ifd := tiffIFD{file: file, base: base, order: order}
+
+	tags, err := ifd.read(int64(order.Uint32(header[4:])))
+	if err != nil {
+		return time.Time{}, ErrNoDate
+	}
+	if entry, exists := tags[tagExifIFD]; exists {
+		exifTags, err := ifd.read(int64(order.Uint32(entry[8:])))
+		if err == nil {
+			for _, tag := range []uint16{tagDateTimeOriginal, tagDateTimeDigitized} {
+				if date, err := ifd.date(exifTags[tag]); err == nil {
+					return date, nil
+				}
+			}
+		}
+	}
+	return ifd.date(tags[tagDateTime])
+}
+
+// tiffIFD reads the image file directories of a TIFF structure. Offsets are relative to base.
+type tiffIFD struct {
+	file  io.ReaderAt
+	base  int64
+	order binary.ByteOrder
+}
+
+// read returns the 12 byte entries of the directory at offset by tag.
+func (t tiffIFD) read(offset int64) (map[uint16][]byte, error) {
+	count := make([]byte, 2)
+	if _, err := t.file.ReadAt(count, t.base+offset); err != nil {
+		return nil, err
+	}
+	entries := make([]byte, 12*int(t.order.Uint16(count)))
+	if _, err := t.file.ReadAt(entries, t.base+offset+2); err != nil {
+		return nil, err
+	}
+	tags := make(map[uint16][]byte)
+	for i := 0; i < len(entries); i += 12 {
+		tags[t.order.Uint16(entries[i:])] = entries[i : i+12]
+	}
+	return tags, nil
+}
+
+// date parses an ASCII date entry.
+func (t tiffIFD) date(entry []byte) (time.Time, error) {
+	// 2 = ASCII, a date is 20 bytes with its terminating NUL
+	if entry == nil || t.order.Uint16(entry[2:]) != 2 || t.order.Uint32(entry[4:]) > 64 {
+		return time.Time{}, ErrNoDate
+	}
+	value := make([]byte, t.order.Uint32(entry[4:]))
+	if len(value) <= 4 {
+		copy(value, entry[8:])
+	} else if _, err := t.file.ReadAt(value, t.base+int64(t.order.Uint32(entry[8:]))); err != nil {
+		return time.Time{}, ErrNoDate
+	}
+	date, err := time.ParseInLocation(exifDateLayout, strings.TrimRight(string(value), "\x00 "), time.Local)
+	if err != nil {
+		return time.Time{}, ErrNoDate
+	}
+	return date, nil
+}
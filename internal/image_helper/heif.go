@@ -0,0 +1,222 @@
+package image_helper
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// heifTools are the libheif command line decoders, tried in order. Unlike ffmpeg before 7.1 they
+// compose grid images (most iPhone photos are stored as a grid of 512x512 tiles).
+var heifTools = []string{"heif-dec", "heif-convert"}
+
+// errNoHEIFSize is returned by heifPrimarySize when the file does not declare the size of its
+// primary image.
+var errNoHEIFSize = errors.New("no size of the primary image")
+
+// decodeHEIF decodes a HEIF/HEIC image with libheif when it is installed, else with ffmpeg. An
+// ffmpeg too old to compose grid images only returns the first tile, which is an error rather
+// than a wrong image.
+func decodeHEIF(path string, file io.Reader) (image.Image, error) {
+	for _, tool := range heifTools {
+		if toolPath, err := exec.LookPath(tool); err == nil {
+			return decodeHEIFTool(toolPath, path)
+		}
+	}
+
+	img, err := decodeFFmpeg(path, file)
+	if err != nil {
+		return nil, err
+	}
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		// The declared size is before rotation, compare areas
+		size, err := heifPrimarySize(seeker)
+		if err == nil && img.Bounds().Dx()*img.Bounds().Dy() < size.X*size.Y {
+			return nil, fmt.Errorf("ffmpeg decoded a %dx%d tile of the %dx%d grid image, install libheif (heif-dec) or ffmpeg 7.1 or newer",
+				img.Bounds().Dx(), img.Bounds().Dy(), size.X, size.Y)
+		}
+	}
+	return img, nil
+}
+
+// decodeHEIFTool converts the file to PNG with a libheif tool and decodes the result.
+func decodeHEIFTool(tool, path string) (image.Image, error) {
+	dir, err := os.MkdirTemp("", "heif")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "image.png")
+	if output, err := exec.Command(tool, path, out).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", filepath.Base(tool), err, lastLine(string(output)))
+	}
+	converted, err := os.Open(out)
+	if err != nil {
+		return nil, err
+	}
+	defer converted.Close()
+	img, _, err := image.Decode(converted)
+	return img, err
+}
+
+// heifPrimarySize reads the size (ispe property) of the primary item of a HEIF file, for grid
+// images the size of the whole composed image.
+func heifPrimarySize(file io.ReadSeeker) (image.Point, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return image.Point{}, err
+	}
+	meta, err := findBox(file, "meta")
+	if err != nil {
+		return image.Point{}, err
+	}
+	if len(meta) < 4 {
+		return image.Point{}, errNoHEIFSize
+	}
+	children := boxes(meta[4:]) // meta is a full box
+
+	pitm := children["pitm"]
+	if len(pitm) < 6 {
+		return image.Point{}, errNoHEIFSize
+	}
+	var primary uint32
+	if pitm[0] == 0 {
+		primary = uint32(binary.BigEndian.Uint16(pitm[4:]))
+	} else if len(pitm) >= 8 {
+		primary = binary.BigEndian.Uint32(pitm[4:])
+	}
+
+	iprp := boxes(children["iprp"])
+	properties := boxList(iprp["ipco"])
+	for _, index := range propertyIndexes(iprp["ipma"], primary) {
+		if index < 1 || index > len(properties) {
+			continue
+		}
+		property := properties[index-1]
+		if property.kind == "ispe" && len(property.data) >= 12 {
+			width := binary.BigEndian.Uint32(property.data[4:])
+			height := binary.BigEndian.Uint32(property.data[8:])
+			return image.Pt(int(width), int(height)), nil
+		}
+	}
+	return image.Point{}, errNoHEIFSize
+}
+
+// propertyIndexes returns the 1 based ipco indexes of the properties associated with the item.
+func propertyIndexes(ipma []byte, item uint32) []int {
+	if len(ipma) < 8 {
+		return nil
+	}
+	version, flags := ipma[0], ipma[3]
+	count := binary.BigEndian.Uint32(ipma[4:])
+	data := ipma[8:]
+	for range count {
+		var id uint32
+		if version < 1 {
+			if len(data) < 3 {
+				return nil
+			}
+			id, data = uint32(binary.BigEndian.Uint16(data)), data[2:]
+		} else {
+			if len(data) < 5 {
+				return nil
+			}
+			id, data = binary.BigEndian.Uint32(data), data[4:]
+		}
+		if len(data) < 1 {
+			return nil
+		}
+		associations := int(data[0])
+		data = data[1:]
+
+		var indexes []int
+		for range associations {
+			if flags&1 != 0 {
+				if len(data) < 2 {
+					return nil
+				}
+				indexes, data = append(indexes, int(binary.BigEndian.Uint16(data)&0x7fff)), data[2:]
+			} else {
+				if len(data) < 1 {
+					return nil
+				}
+				indexes, data = append(indexes, int(data[0]&0x7f)), data[1:]
+			}
+		}
+		if id == item {
+			return indexes
+		}
+	}
+	return nil
+}
+
+// box is an ISO base media file format box.
+type box struct {
+	kind string
+	data []byte // Content after the header
+}
+
+// findBox reads the top level boxes of the file until the one of the given kind and returns its
+// content.
+func findBox(file io.ReadSeeker, kind string) ([]byte, error) {
+	header := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(file, header[:8]); err != nil {
+			return nil, err
+		}
+		size, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		switch size {
+		case 0:
+			return nil, fmt.Errorf("%s box not found", kind)
+		case 1:
+			if _, err := io.ReadFull(file, header[8:16]); err != nil {
+				return nil, err
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if size < headerSize {
+			return nil, fmt.Errorf("invalid box size %d", size)
+		}
+		if string(header[4:8]) == kind {
+			if size > 16<<20 {
+				return nil, fmt.Errorf("%s box too large", kind)
+			}
+			data := make([]byte, size-headerSize)
+			_, err := io.ReadFull(file, data)
+			return data, err
+		}
+		if _, err := file.Seek(size-headerSize, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// boxList splits the content of a container box into its child boxes.
+func boxList(data []byte) []box {
+	var list []box
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			break
+		}
+		list = append(list, box{kind: string(data[4:8]), data: data[8:size]})
+		data = data[size:]
+	}
+	return list
+}
+
+// boxes returns the child boxes of a container box by kind, the first of each kind.
+func boxes(data []byte) map[string][]byte {
+	children := make(map[string][]byte)
+	for _, child := range boxList(data) {
+		if _, exists := children[child.kind]; !exists {
+			children[child.kind] = child.data
+		}
+	}
+	return children
+}
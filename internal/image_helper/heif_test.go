@@ -0,0 +1,80 @@
+package image_helper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+// makeBox encodes an ISO base media box.
+func makeBox(kind string, parts ...[]byte) []byte {
+	content := bytes.Join(parts, nil)
+	data := binary.BigEndian.AppendUint32(nil, uint32(8+len(content)))
+	return append(append(data, kind...), content...)
+}
+
+// fullBox returns the version and flags header of a full box.
+func fullBox(version byte, flags uint32) []byte {
+	return []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+}
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+func ispe(width, height uint32) []byte {
+	return makeBox("ispe", fullBox(0, 0), u32(width), u32(height))
+}
+
+func TestHEIFPrimarySize(t *testing.T) {
+	ftyp := makeBox("ftyp", []byte("heic"), u32(0), []byte("mif1heic"))
+	mdat := makeBox("mdat", make([]byte, 100))
+	// Item 1 is a 512x512 tile, item 2 the 4032x3024 grid and the primary item
+	ipco := makeBox("ipco", ispe(512, 512), makeBox("hvcC", make([]byte, 4)), ispe(4032, 3024))
+
+	tests := []struct {
+		name string
+		file []byte
+		want image.Point
+	}{
+		{
+			name: "one byte associations",
+			file: append(append(ftyp, makeBox("meta", fullBox(0, 0),
+				makeBox("hdlr", fullBox(0, 0), make([]byte, 20)),
+				makeBox("pitm", fullBox(0, 0), u16(2)),
+				makeBox("iprp", ipco, makeBox("ipma", fullBox(0, 0), u32(2),
+					u16(1), []byte{2, 0x81, 0x02},
+					u16(2), []byte{1, 0x83})),
+			)...), mdat...),
+			want: image.Pt(4032, 3024),
+		},
+		{
+			name: "two byte associations and 32 bit item IDs",
+			file: append(append(ftyp, mdat...), makeBox("meta", fullBox(0, 0),
+				makeBox("pitm", fullBox(1, 0), u32(2)),
+				makeBox("iprp", ipco, makeBox("ipma", fullBox(1, 1), u32(2),
+					u32(1), []byte{1}, u16(0x8001),
+					u32(2), []byte{1}, u16(3))),
+			)...),
+			want: image.Pt(4032, 3024),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := heifPrimarySize(bytes.NewReader(test.file))
+			if err != nil {
+				t.Fatalf("heifPrimarySize: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("heifPrimarySize = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHEIFPrimarySizeWithoutMeta(t *testing.T) {
+	file := makeBox("ftyp", []byte("heic"), u32(0))
+	if _, err := heifPrimarySize(bytes.NewReader(file)); err == nil {
+		t.Error("heifPrimarySize succeeded on a file without meta box")
+	}
+}
@@ -0,0 +1,175 @@
+package image_helper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // Register GIF decoder
+	_ "image/jpeg" // Register JPEG decoder
+	_ "image/png"  // Register PNG decoder
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/h2non/filetype"
+	"github.com/h2non/filetype/types"
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+	_ "golang.org/x/image/bmp"  // Register BMP decoder
+	_ "golang.org/x/image/tiff" // Register TIFF decoder
+	_ "golang.org/x/image/webp" // Register WebP decoder
+)
+
+// Number of header bytes filetype needs to detect every type it knows
+const sniffLength = 261
+
+// ErrNotImage is returned when the content of a file is not a supported image.
+var ErrNotImage = errors.New("not a supported image")
+
+// extensions lists the file extensions (lowercase) of every format Decode supports.
+var extensions = map[string]struct{}{
+	".png":  {},
+	".jpg":  {},
+	".jpeg": {},
+	".gif":  {},
+	".bmp":  {},
+	".tif":  {},
+	".tiff": {},
+	".webp": {},
+	".heic": {},
+	".heif": {},
+}
+
+// decoderFunc decodes the image file at path.
+type decoderFunc func(path string, file io.Reader) (image.Image, error)
+
+// decoders maps the sniffed MIME type of a file to its decoder. Formats with a Go decoder go
+// through image.Decode, HEIF is converted by a local libheif or ffmpeg, see decodeHEIF.
+var decoders = map[string]decoderFunc{
+	"image/jpeg": decodeStd,
+	"image/png":  decodeStd,
+	"image/gif":  decodeStd,
+	"image/bmp":  decodeStd,
+	"image/tiff": decodeStd,
+	"image/webp": decodeStd,
+	"image/heif": decodeHEIF,
+}
+
+// HasImageExtension checks if a file path has the extension of a supported image format.
+// It is only a cheap pre-filter, the format is always detected from the content.
+func HasImageExtension(path string) bool {
+	_, supported := extensions[strings.ToLower(filepath.Ext(path))]
+	return supported
+}
+
+// DetectType sniffs the header of the file and returns its type (filetype.Unknown if unknown).
+func DetectType(path string) (types.Type, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return filetype.Unknown, err
+	}
+	defer file.Close()
+
+	return detectType(file)
+}
+
+func detectType(file io.Reader) (types.Type, error) {
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return filetype.Unknown, err
+	}
+	return filetype.Match(head[:n])
+}
+
+// IsImage reports whether the content of the file is an image format Decode supports.
+func IsImage(path string) bool {
+	kind, err := DetectType(path)
+	if err != nil {
+		return false
+	}
+	_, supported := decoders[kind.MIME.Value]
+	return supported
+}
+
+// Decode detects the format of the file from its content and decodes it.
+// It returns the decoded image and the detected type.
+func Decode(path string) (image.Image, types.Type, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, filetype.Unknown, err
+	}
+	defer file.Close()
+
+	kind, err := detectType(file)
+	if err != nil {
+		return nil, filetype.Unknown, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	decode, supported := decoders[kind.MIME.Value]
+	if !supported {
+		detected := kind.MIME.Value
+		if kind == filetype.Unknown {
+			detected = "unknown content"
+		}
+		return nil, kind, fmt.Errorf("%s: %w (%s)", path, ErrNotImage, detected)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, kind, err
+	}
+
+	img, err := decode(path, file)
+	if err != nil {
+		return nil, kind, fmt.Errorf("error decoding %s as %s: %w", path, kind.MIME.Value, err)
+	}
+	return img, kind, nil
+}
+
+// DecodeConfig returns the dimensions of an image without decoding it when the format allows it.
+func DecodeConfig(path string) (image.Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err == nil {
+		return config, nil
+	}
+	// Formats without a Go decoder have to be decoded entirely
+	img, _, decodeErr := Decode(path)
+	if decodeErr != nil {
+		return image.Config{}, decodeErr
+	}
+	bounds := img.Bounds()
+	return image.Config{ColorModel: img.ColorModel(), Width: bounds.Dx(), Height: bounds.Dy()}, nil
+}
+
+// decodeStd decodes with the decoders registered in the image package.
+func decodeStd(path string, file io.Reader) (image.Image, error) {
+	img, _, err := image.Decode(file)
+	return img, err
+}
+
+// decodeFFmpeg converts the first frame of the file to PNG with ffmpeg and decodes the result.
+func decodeFFmpeg(path string, file io.Reader) (image.Image, error) {
+	var out, stderr bytes.Buffer
+	err := ffmpeg.Input(path).
+		Output("pipe:", ffmpeg.KwArgs{"format": "image2", "vcodec": "png", "frames:v": 1}).
+		WithOutput(&out).
+		WithErrorOutput(&stderr).
+		Run()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w: %s", err, lastLine(stderr.String()))
+	}
+
+	img, _, err := image.Decode(&out)
+	return img, err
+}
+
+// lastLine returns the last non-empty line of an ffmpeg log, which usually holds the error.
+func lastLine(log string) string {
+	lines := strings.Split(strings.TrimSpace(log), "\n")
+	return lines[len(lines)-1]
+}
@@ -0,0 +1,104 @@
+package video_helper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// showinfoTimestamp matches the presentation time the showinfo filter logs for every frame.
+var showinfoTimestamp = regexp.MustCompile(`Parsed_showinfo_\d+ @ [^\]]+\] n:\s*\d+\s+pts:\s*-?\d+\s+pts_time:(-?[0-9.]+)`)
+
+// KeyframeOptions selects which keyframes Keyframes decodes.
+type KeyframeOptions struct {
+	MinInterval time.Duration // Keyframes closer than this to the previous sampled one are skipped
+	Size        int           // Frames are scaled to Size x Size pixels, ignoring the aspect ratio
+}
+
+// Keyframes decodes the keyframes of the first video stream of a file with a local ffmpeg.
+// Frames are streamed to fn in presentation order as Size x Size RGBA images, so a long video
+// is never held in memory; the image is reused for the next frame once fn returns. ffmpeg
+// only logs the timestamps of the frames, so they are returned, in the same order, once the
+// whole video has been decoded.
+func Keyframes(path string, opts KeyframeOptions, fn func(frame *image.NRGBA) error) ([]time.Duration, error) {
+	if opts.Size <= 0 {
+		return nil, fmt.Errorf("invalid frame size %d", opts.Size)
+	}
+	filters := []string{
+		fmt.Sprintf("select='isnan(prev_selected_t)+gte(t-prev_selected_t,%.3f)'", opts.MinInterval.Seconds()),
+		fmt.Sprintf("scale=%d:%d", opts.Size, opts.Size),
+		"showinfo",
+	}
+
+	reader, writer := io.Pipe()
+	var stderr bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		err := ffmpeg.Input(path, ffmpeg.KwArgs{"skip_frame": "nokey"}).
+			Output("pipe:", ffmpeg.KwArgs{"format": "rawvideo", "pix_fmt": "rgba", "vf": strings.Join(filters, ","), "vsync": "passthrough", "an": ""}).
+			WithOutput(writer).
+			WithErrorOutput(&stderr).
+			Run()
+		writer.CloseWithError(err) // A nil error closes the pipe with io.EOF
+		done <- err
+	}()
+
+	frame := image.NewNRGBA(image.Rect(0, 0, opts.Size, opts.Size))
+	frames := 0
+	var readErr error
+	for {
+		if _, readErr = io.ReadFull(reader, frame.Pix); readErr != nil {
+			break
+		}
+		if readErr = fn(frame); readErr != nil {
+			break
+		}
+		frames++
+	}
+	// Stop ffmpeg when the frames are no longer read, it fails on its next write
+	reader.CloseWithError(readErr)
+	runErr := <-done
+
+	switch {
+	case runErr != nil:
+		if readErr != nil && !errors.Is(readErr, io.EOF) && !errors.Is(readErr, runErr) {
+			return nil, readErr
+		}
+		return nil, fmt.Errorf("ffmpeg failed: %w: %s", runErr, lastLine(stderr.String()))
+	case !errors.Is(readErr, io.EOF):
+		return nil, readErr
+	}
+
+	timestamps := parseTimestamps(stderr.String())
+	if len(timestamps) != frames {
+		return nil, fmt.Errorf("ffmpeg logged %d timestamps for %d frames", len(timestamps), frames)
+	}
+	return timestamps, nil
+}
+
+// parseTimestamps returns the frame timestamps logged by the showinfo filter.
+func parseTimestamps(log string) []time.Duration {
+	var timestamps []time.Duration
+	for _, match := range showinfoTimestamp.FindAllStringSubmatch(log, -1) {
+		seconds, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			continue
+		}
+		timestamps = append(timestamps, time.Duration(seconds*float64(time.Second)))
+	}
+	return timestamps
+}
+
+// lastLine returns the last non-empty line of an ffmpeg log, which usually holds the error.
+func lastLine(log string) string {
+	lines := strings.Split(strings.TrimSpace(log), "\n")
+	return lines[len(lines)-1]
+}
@@ -8,7 +8,6 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -142,7 +141,7 @@ func getAllVideos(folder string) ([]string, error) {
 			return filepath.SkipDir
 		}
 
-		if !info.IsDir() && isVideo(path) {
+		if !info.IsDir() && common.IsVideo(path) {
 			videos = append(videos, path)
 		}
 
@@ -152,18 +151,6 @@ func getAllVideos(folder string) ([]string, error) {
 	return videos, err
 }
 
-func isVideo(filename string) bool {
-	videoExtensions := []string{".mp4", ".mkv", ".avi", ".mov", ".flv", ".wmv", ".mts"}
-	ext := strings.ToLower(filepath.Ext(filename))
-	for _, videoExt := range videoExtensions {
-		if ext == videoExt {
-			return true
-		}
-	}
-
-	return false
-}
-
 func isBitrateLarge(resolution string, bitrate int) bool {
 	var threshold int
 